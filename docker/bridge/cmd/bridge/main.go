@@ -1,32 +1,71 @@
 package main
 
 import (
+	"bridge/internal/admin"
+	"bridge/internal/health"
+	"bridge/internal/logging"
+	"bridge/internal/metrics"
+	"bridge/internal/tracing"
 	"bridge/internal/worker" // <-- Import worker package
 	"bridge/internal/ws"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// shutdownTimeout bounds how long a signalled shutdown waits for requests in
+// flight.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	// Load environment variables from .env file
-	if err := godotenv.Load(); err != nil {
-		log.Printf("[BRIDGE] Warning: Error loading .env file: %v", err)
+	// Load environment variables from .env file before configuring logging,
+	// so LOG_LEVEL and friends can be set there.
+	envErr := godotenv.Load()
+	logging.Init("bridge", logging.KeyWorkspaceID, os.Getenv("WORKSPACE_ID"))
+	if envErr != nil {
+		slog.Warn("Error loading .env file", "error", envErr)
 	} else {
-		log.Println("[BRIDGE] Environment variables loaded from .env file")
+		slog.Info("Environment variables loaded from .env file")
+	}
+
+	traceExporter, err := tracing.Init("bridge")
+	if err != nil {
+		slog.Error("Failed to configure tracing, spans will be dropped", "error", err)
+	}
+	// os.Exit skips deferred calls, so main leaves through exit, which flushes
+	// the traces first.
+	exit := func(code int) {
+		if traceExporter != nil {
+			traceExporter.Shutdown()
+		}
+		os.Exit(code)
 	}
 
 	hub := ws.NewHub()
 	go hub.Run()
 
-	worker.GetInstance()
+	workerClient := worker.GetInstance()
 
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		ws.ServeWs(hub, w, r)
 	})
 
+	// The admin API is only mounted when a token is configured.
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		http.Handle("/admin/", admin.NewHandler(hub, workerClient, adminToken))
+		slog.Info("Admin API enabled", "path", "/admin/")
+	} else {
+		slog.Info("ADMIN_TOKEN not set, admin API disabled")
+	}
+
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		// Set the content type header to plain text
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -36,10 +75,55 @@ func main() {
 		fmt.Fprintln(w, "OK BRIDGE")
 	})
 
-	log.Println("[BRIDGE] Starting WebSocket server on localhost:2024...")
-	log.Println("[BRIDGE] Go to http://localhost:2024/health...")
-	err := http.ListenAndServe(":2024", nil)
-	if err != nil {
-		log.Fatalf("[BRIDGE] ListenAndServe failed: %v", err)
+	checker := health.NewChecker()
+	checker.Register("worker", func(ctx context.Context) (map[string]interface{}, error) {
+		connected := workerClient.IsConnected()
+		details := map[string]interface{}{"connected": connected}
+		if !connected {
+			return details, fmt.Errorf("not connected to worker")
+		}
+		return details, nil
+	})
+	checker.Register("hydration", func(ctx context.Context) (map[string]interface{}, error) {
+		status := workerClient.Hydration()
+		details := map[string]interface{}{"status": status}
+		// A pending hydration is ready: hydration is only triggered by the
+		// first client's init, which could never arrive on an unready pod.
+		switch status.State {
+		case worker.HydrationInProgress:
+			return details, fmt.Errorf("hydration in progress")
+		case worker.HydrationFailed:
+			return details, fmt.Errorf("hydration failed")
+		}
+		return details, nil
+	})
+	http.Handle("/healthz", health.LivenessHandler())
+	http.Handle("/readyz", checker.ReadinessHandler())
+
+	http.Handle("/metrics", metrics.Handler())
+
+	server := &http.Server{Addr: ":2024"}
+	// On SIGTERM or SIGINT stop accepting connections and let requests in
+	// flight finish, for up to shutdownTimeout. A second signal kills at once.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		stop()
+		slog.Info("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Server shutdown did not finish", "error", err)
+		}
+	}()
+
+	slog.Info("Starting WebSocket server", "addr", ":2024", "health", "http://localhost:2024/health")
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("ListenAndServe failed", "error", err)
+		exit(1)
 	}
+	<-stopped
+	exit(0)
 }
@@ -0,0 +1,182 @@
+// Package admin serves an authenticated HTTP API for inspecting and poking a
+// live workspace: connected clients, in-flight worker commands, terminals,
+// event bus subscribers, hydration and persistence.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"bridge/internal/bus"
+	"bridge/internal/logging"
+	"bridge/internal/tracing"
+	"bridge/internal/worker"
+	"bridge/internal/ws"
+	"bridge/pkg/types"
+
+	"github.com/google/uuid"
+)
+
+func logger() *slog.Logger {
+	return slog.With(logging.KeyComponent, "admin")
+}
+
+type Handler struct {
+	hub      *ws.Hub
+	worker   *worker.Client
+	eventBus *bus.EventBus
+	token    string
+	mux      *http.ServeMux
+}
+
+// NewHandler returns the admin API, mounted under /admin/. Every request must
+// carry "Authorization: Bearer <token>".
+func NewHandler(hub *ws.Hub, workerClient *worker.Client, token string) *Handler {
+	h := &Handler{
+		hub:      hub,
+		worker:   workerClient,
+		eventBus: bus.GetInstance(),
+		token:    token,
+		mux:      http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /admin/clients", h.listClients)
+	h.mux.HandleFunc("POST /admin/clients/{id}/kick", h.kickClient)
+	h.mux.HandleFunc("GET /admin/acks", h.listPendingAcks)
+	h.mux.HandleFunc("GET /admin/terminals", h.listTerminals)
+	h.mux.HandleFunc("GET /admin/bus", h.listSubscribers)
+	h.mux.HandleFunc("GET /admin/hydration", h.hydrationStatus)
+	h.mux.HandleFunc("POST /admin/hydrate", h.rehydrate)
+	h.mux.HandleFunc("POST /admin/persist", h.persist)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		logger().Warn("Rejected admin request", "method", r.Method, "path", r.URL.Path, "remoteAddr", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || h.token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) == 1
+}
+
+func (h *Handler) listClients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"clients": h.hub.ListClients()})
+}
+
+func (h *Handler) kickClient(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "kicked by admin"
+	}
+	logger().Info("Admin kicking client", logging.KeyClientID, id, "reason", reason)
+	if err := h.hub.Kick(id, reason); errors.Is(err, ws.ErrUnknownClient) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("client %s not found", id))
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"kicked": id})
+}
+
+func (h *Handler) listPendingAcks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"pending": h.worker.PendingAcks()})
+}
+
+func (h *Handler) listTerminals(w http.ResponseWriter, r *http.Request) {
+	data, err := h.forward(r.Context(), "list-terminals", map[string]interface{}{})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"terminals": data["terminals"]})
+}
+
+func (h *Handler) listSubscribers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"subscribers": h.eventBus.Subscribers()})
+}
+
+func (h *Handler) hydrationStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.worker.Hydration())
+}
+
+// rehydrate starts a new hydration in the background; poll /admin/hydration
+// for its progress.
+func (h *Handler) rehydrate(w http.ResponseWriter, r *http.Request) {
+	if !h.worker.StartHydration() {
+		writeError(w, http.StatusConflict, "hydration already in progress")
+		return
+	}
+	logger().Info("Admin triggered re-hydration")
+	// Detach from the request context, which is cancelled once we reply.
+	ctx, span := tracing.Start(context.Background(), "admin.hydrate")
+	go func() {
+		defer span.End()
+		h.worker.TriggerHydration(ctx)
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "started"})
+}
+
+// persist asks the worker to commit outstanding workspace changes.
+func (h *Handler) persist(w http.ResponseWriter, r *http.Request) {
+	message := r.URL.Query().Get("message")
+	if message == "" {
+		message = "Admin-triggered snapshot"
+	}
+	logger().Info("Admin triggered persistence", "message", message)
+	data, err := h.forward(r.Context(), "system:commit", map[string]interface{}{"message": message})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	delete(data, "ackID")
+	writeJSON(w, http.StatusOK, data)
+}
+
+// forward sends an admin-originated request to the worker and returns the
+// acknowledgement payload, turning a worker-reported error into an error.
+func (h *Handler) forward(ctx context.Context, event string, data map[string]interface{}) (map[string]interface{}, error) {
+	ctx, span := tracing.Start(ctx, "admin."+event)
+	defer span.End()
+
+	msg := &types.Message{Event: event, Data: data}
+	ack, err := h.worker.ForwardCommand(ctx, msg, uuid.New().String())
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	ackData, _ := ack.Data.(map[string]interface{})
+	if errMsg, ok := ackData["error"].(string); ok && errMsg != "" {
+		err = fmt.Errorf("worker: %s", errMsg)
+		span.RecordError(err)
+		return nil, err
+	}
+	return ackData, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
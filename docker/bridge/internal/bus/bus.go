@@ -3,6 +3,7 @@ package bus
 
 import (
 	"bridge/pkg/types"
+	"sort"
 	"sync"
 )
 
@@ -10,6 +11,14 @@ import (
 type EventBus struct {
 	subscribers map[string][]chan *types.Message
 	mu          sync.RWMutex
+	// queue hands published messages to a single dispatcher, so subscribers
+	// see them in publish order without blocking the publisher.
+	queue chan published
+}
+
+type published struct {
+	chans []chan *types.Message
+	msg   *types.Message
 }
 
 var (
@@ -21,7 +30,9 @@ func GetInstance() *EventBus {
 	once.Do(func() {
 		busInstance = &EventBus{
 			subscribers: make(map[string][]chan *types.Message),
+			queue:       make(chan published, 1024),
 		}
+		go busInstance.dispatch()
 	})
 	return busInstance
 }
@@ -36,11 +47,35 @@ func (b *EventBus) Publish(topic string, msg *types.Message) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 	if chans, found := b.subscribers[topic]; found {
-		// Use a goroutine to prevent blocking the publisher
-		go func() {
-			for _, ch := range chans {
-				ch <- msg
-			}
-		}()
+		b.queue <- published{chans: chans, msg: msg}
+	}
+}
+
+func (b *EventBus) dispatch() {
+	for p := range b.queue {
+		for _, ch := range p.chans {
+			ch <- p.msg
+		}
 	}
-}
\ No newline at end of file
+}
+
+// SubscriberInfo describes one subscription, for debugging.
+type SubscriberInfo struct {
+	Topic    string `json:"topic"`
+	Buffered int    `json:"buffered"` // Messages waiting in the subscriber's channel
+	Capacity int    `json:"capacity"`
+}
+
+// Subscribers lists every subscription, ordered by topic.
+func (b *EventBus) Subscribers() []SubscriberInfo {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	infos := []SubscriberInfo{}
+	for topic, chans := range b.subscribers {
+		for _, ch := range chans {
+			infos = append(infos, SubscriberInfo{Topic: topic, Buffered: len(ch), Capacity: cap(ch)})
+		}
+	}
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].Topic < infos[j].Topic })
+	return infos
+}
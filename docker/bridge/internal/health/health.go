@@ -0,0 +1,134 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check reports the health of one dependency. Details are optional and are
+// included in the readiness response whether or not the check passes.
+type Check func(ctx context.Context) (details map[string]interface{}, err error)
+
+// Result is the outcome of a single check as rendered by /readyz.
+type Result struct {
+	Status     string                 `json:"status"` // "ok" or "fail"
+	Error      string                 `json:"error,omitempty"`
+	DurationMs float64                `json:"durationMs"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// Report is the JSON body returned by /readyz.
+type Report struct {
+	Status string            `json:"status"` // "ready" or "not_ready"
+	Checks map[string]Result `json:"checks"`
+}
+
+// Checker runs named readiness checks.
+type Checker struct {
+	checks  map[string]Check
+	timeout time.Duration
+	mu      sync.RWMutex
+}
+
+func NewChecker() *Checker {
+	return &Checker{checks: make(map[string]Check), timeout: 2 * time.Second}
+}
+
+// Register adds or replaces a readiness check.
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Run executes every check concurrently and aggregates the results.
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	c.mu.RLock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = c.checks[name]
+	}
+	c.mu.RUnlock()
+
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	report := Report{Status: "ready", Checks: make(map[string]Result, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != "ok" {
+			report.Status = "not_ready"
+		}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, check Check) Result {
+	start := time.Now()
+	done := make(chan Result, 1)
+	go func() {
+		details, err := check(ctx)
+		r := Result{Status: "ok", Details: details}
+		if err != nil {
+			r.Status = "fail"
+			r.Error = err.Error()
+		}
+		done <- r
+	}()
+
+	var r Result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		r = Result{Status: "fail", Error: "check timed out"}
+	}
+	r.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	return r
+}
+
+// LivenessHandler serves /healthz. It only proves the process can serve HTTP.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// ReadinessHandler serves /readyz: 200 when every check passes, 503 otherwise,
+// with a per-check JSON breakdown in both cases.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+		status := http.StatusOK
+		if report.Status != "ready" {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,354 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A minimal, dependency-free implementation of the Prometheus text exposition
+// format. It only supports what the bridge needs: counters, gauges and
+// histograms with labels, plus gauges evaluated at scrape time.
+
+// DefBuckets are latency buckets (in seconds) suited to websocket round trips.
+var DefBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds every registered metric and renders them on scrape.
+type Registry struct {
+	collectors map[string]collector
+	mu         sync.RWMutex
+}
+
+var defaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.collectors[c.name()]; exists {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", c.name()))
+	}
+	r.collectors[c.name()] = c
+}
+
+// Render renders all metrics, sorted by name, in the Prometheus text format.
+func (r *Registry) Render(w io.Writer) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]collector, 0, len(names))
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.mu.RUnlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the default registry on /metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		defaultRegistry.Render(w)
+	})
+}
+
+// ============================================================================
+// LABELS
+// ============================================================================
+
+type labeled struct {
+	metricName string
+	help       string
+	kind       string
+	labelNames []string
+}
+
+func (l *labeled) name() string { return l.metricName }
+
+func (l *labeled) key(values []string) string {
+	if len(values) != len(l.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", l.metricName, len(l.labelNames), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (l *labeled) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", l.metricName, l.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", l.metricName, l.kind)
+}
+
+// formatLabels renders {a="x",b="y"}, appending any extra pairs (e.g. "le").
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	first := true
+	write := func(name, value string) {
+		if !first {
+			b.WriteByte(',')
+		}
+		first = false
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(value))
+		b.WriteByte('"')
+	}
+	for i, name := range names {
+		write(name, values[i])
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		write(extra[i], extra[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escapeLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return strings.ReplaceAll(v, `"`, `\"`)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the series keys of a vector in a stable order.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitKey(key string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	return strings.SplitN(key, "\xff", n)
+}
+
+// ============================================================================
+// COUNTERS & GAUGES
+// ============================================================================
+
+// Value is a single float series used by both counters and gauges.
+type Value struct {
+	v  float64
+	mu sync.Mutex
+}
+
+func (v *Value) Add(delta float64) {
+	v.mu.Lock()
+	v.v += delta
+	v.mu.Unlock()
+}
+
+func (v *Value) Inc() { v.Add(1) }
+func (v *Value) Dec() { v.Add(-1) }
+
+func (v *Value) Set(value float64) {
+	v.mu.Lock()
+	v.v = value
+	v.mu.Unlock()
+}
+
+func (v *Value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+// ValueVec is a family of counters or gauges partitioned by labels.
+type ValueVec struct {
+	labeled
+	series map[string]*Value
+	mu     sync.Mutex
+}
+
+func newValueVec(kind, name, help string, labelNames []string) *ValueVec {
+	v := &ValueVec{
+		labeled: labeled{metricName: name, help: help, kind: kind, labelNames: labelNames},
+		series:  make(map[string]*Value),
+	}
+	defaultRegistry.register(v)
+	return v
+}
+
+// NewCounterVec registers a monotonically increasing counter family.
+func NewCounterVec(name, help string, labelNames ...string) *ValueVec {
+	return newValueVec("counter", name, help, labelNames)
+}
+
+// NewGaugeVec registers a gauge family.
+func NewGaugeVec(name, help string, labelNames ...string) *ValueVec {
+	return newValueVec("gauge", name, help, labelNames)
+}
+
+// NewCounter registers an unlabeled counter.
+func NewCounter(name, help string) *Value {
+	return NewCounterVec(name, help).WithLabelValues()
+}
+
+// NewGauge registers an unlabeled gauge.
+func NewGauge(name, help string) *Value {
+	return NewGaugeVec(name, help).WithLabelValues()
+}
+
+func (v *ValueVec) WithLabelValues(values ...string) *Value {
+	key := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &Value{}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *ValueVec) write(w io.Writer) {
+	v.writeHeader(w)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range sortedKeys(v.series) {
+		labels := formatLabels(v.labelNames, splitKey(key, len(v.labelNames)))
+		fmt.Fprintf(w, "%s%s %s\n", v.metricName, labels, formatFloat(v.series[key].get()))
+	}
+}
+
+// GaugeFunc is a gauge whose value is computed at scrape time.
+type GaugeFunc struct {
+	labeled
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge backed by fn, which must be safe for concurrent use.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{labeled: labeled{metricName: name, help: help, kind: "gauge"}, fn: fn}
+	defaultRegistry.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.fn()))
+}
+
+// ============================================================================
+// HISTOGRAMS
+// ============================================================================
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	upperBounds []float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+	mu          sync.Mutex
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.upperBounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// HistogramVec is a family of histograms partitioned by labels.
+type HistogramVec struct {
+	labeled
+	buckets []float64
+	series  map[string]*Histogram
+	mu      sync.Mutex
+}
+
+// NewHistogramVec registers a histogram family. A nil buckets slice uses DefBuckets.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &HistogramVec{
+		labeled: labeled{metricName: name, help: help, kind: "histogram", labelNames: labelNames},
+		buckets: sorted,
+		series:  make(map[string]*Histogram),
+	}
+	defaultRegistry.register(h)
+	return h
+}
+
+// NewHistogram registers an unlabeled histogram.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return NewHistogramVec(name, help, buckets).WithLabelValues()
+}
+
+func (h *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &Histogram{upperBounds: h.buckets, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	return s
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		values := splitKey(key, len(h.labelNames))
+		s := h.series[key]
+		s.mu.Lock()
+		for i, bound := range s.upperBounds {
+			labels := formatLabels(h.labelNames, values, "le", formatFloat(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labels, s.counts[i])
+		}
+		labels := formatLabels(h.labelNames, values, "le", "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labels, s.count)
+		labels = formatLabels(h.labelNames, values)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labels, s.count)
+		s.mu.Unlock()
+	}
+}
@@ -1,7 +1,7 @@
 package minioClient
 
 import (
-	"log"
+	"log/slog"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -21,6 +21,6 @@ func NewClient() (*minio.Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	log.Println("BRIDGE: ✅ Connected to MinIO.")
+	slog.Info("Connected to MinIO", "endpoint", endpoint)
 	return minioClient, nil
 }
\ No newline at end of file
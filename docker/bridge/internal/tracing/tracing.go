@@ -0,0 +1,213 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A small OpenTelemetry-style tracer. Span context travels between services as
+// a W3C traceparent string ("00-<trace-id>-<span-id>-<flags>") inside the
+// message envelope, and finished spans are handed to a pluggable Exporter.
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// TraceParent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-01"
+}
+
+// ParseTraceParent parses a W3C traceparent value. It returns false for
+// anything malformed, in which case the caller should start a new trace.
+func ParseTraceParent(s string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	return sc, sc.IsValid()
+}
+
+// ============================================================================
+// CONTEXT PROPAGATION
+// ============================================================================
+
+type spanContextKey struct{}
+
+// ContextWithRemoteParent makes spans started from the returned context
+// children of the span described by traceparent. Invalid values are ignored.
+func ContextWithRemoteParent(ctx context.Context, traceparent string) context.Context {
+	if sc, ok := ParseTraceParent(traceparent); ok {
+		return context.WithValue(ctx, spanContextKey{}, sc)
+	}
+	return ctx
+}
+
+// SpanContextFromContext returns the current span context, if any.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// TraceParentFromContext returns the traceparent to send downstream, or "".
+func TraceParentFromContext(ctx context.Context) string {
+	sc, _ := SpanContextFromContext(ctx)
+	return sc.TraceParent()
+}
+
+// TraceIDFromContext returns the current trace ID as hex, or "".
+func TraceIDFromContext(ctx context.Context) string {
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		return sc.TraceID.String()
+	}
+	return ""
+}
+
+// ============================================================================
+// SPANS
+// ============================================================================
+
+// SpanData is the immutable record handed to exporters when a span ends.
+type SpanData struct {
+	Name         string                 `json:"name"`
+	Service      string                 `json:"service"`
+	TraceID      string                 `json:"traceId"`
+	SpanID       string                 `json:"spanId"`
+	ParentSpanID string                 `json:"parentSpanId,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	DurationMs   float64                `json:"durationMs"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Status       string                 `json:"status"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+// Span is an in-flight operation. A nil *Span is valid and does nothing.
+type Span struct {
+	data  SpanData
+	ended bool
+	mu    sync.Mutex
+}
+
+// Start begins a span named name as a child of the span in ctx (or as the root
+// of a new trace) and returns a context carrying it. attrs are key/value pairs.
+func Start(ctx context.Context, name string, attrs ...interface{}) (context.Context, *Span) {
+	sc := SpanContext{SpanID: newSpanID()}
+	parent, hasParent := SpanContextFromContext(ctx)
+	if hasParent {
+		sc.TraceID = parent.TraceID
+	} else {
+		sc.TraceID = newTraceID()
+	}
+
+	span := &Span{data: SpanData{
+		Name:    name,
+		Service: serviceName(),
+		TraceID: sc.TraceID.String(),
+		SpanID:  sc.SpanID.String(),
+		Start:   time.Now(),
+		Status:  "ok",
+	}}
+	if hasParent {
+		span.data.ParentSpanID = parent.SpanID.String()
+	}
+	span.SetAttributes(attrs...)
+	return context.WithValue(ctx, spanContextKey{}, sc), span
+}
+
+// SetAttributes records key/value pairs on the span.
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			continue
+		}
+		if s.data.Attributes == nil {
+			s.data.Attributes = make(map[string]interface{})
+		}
+		s.data.Attributes[key] = kv[i+1]
+	}
+}
+
+// RecordError marks the span as failed. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status = "error"
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and exports it. Calling End more than once is a no-op.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	s.data.DurationMs = float64(s.data.End.Sub(s.data.Start).Microseconds()) / 1000
+	data := s.data
+	s.mu.Unlock()
+
+	currentExporter().Export(data)
+}
+
+// EndErr records *errp (if non-nil) and ends the span. It is meant to be
+// deferred with a named error return: defer span.EndErr(&err).
+func (s *Span) EndErr(errp *error) {
+	if errp != nil {
+		s.RecordError(*errp)
+	}
+	s.End()
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
@@ -5,15 +5,19 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"bridge/internal/bus"
+	"bridge/internal/logging"
+	"bridge/internal/metrics"
 	"bridge/internal/minioClient"
+	"bridge/internal/tracing"
 	"bridge/pkg/types"
 
 	"github.com/gorilla/websocket"
@@ -25,25 +29,130 @@ var (
 	client *Client
 )
 
+var (
+	workerMessages  = metrics.NewCounterVec("bridge_worker_messages_total", "Messages exchanged with the worker, by direction and event type.", "direction", "event")
+	ackLatency      = metrics.NewHistogramVec("bridge_ack_latency_seconds", "Time between forwarding a command to the worker and receiving its acknowledgement.", nil, "event", "outcome")
+	workerConnected = metrics.NewGauge("bridge_worker_connected", "Whether the bridge currently holds a connection to the worker (1) or not (0).")
+
+	hydrationDuration = metrics.NewHistogram("bridge_hydration_duration_seconds", "Duration of workspace hydration from object storage.", []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300})
+	hydrationBytes    = metrics.NewCounter("bridge_hydration_bytes_total", "Bytes read from object storage during hydration.")
+	hydrationFiles    = metrics.NewCounterVec("bridge_hydration_files_total", "Files processed during hydration, by outcome.", "outcome")
+)
+
+// streamEvents are worker events that belong to an in-flight command. They
+// carry the command's ackID and go only to the client that sent it, ahead of
+// the acknowledgement.
+var streamEvents = map[string]bool{
+	"exec-output":    true,
+	"search-results": true,
+	"file-chunk":     true,
+}
+
+// broadcastEvents are worker events relayed to every frontend client.
+var broadcastEvents = map[string]bool{
+	"file-changed":         true,
+	"terminal-data":        true,
+	"terminal-resized":     true,
+	"terminal-created":     true,
+	"terminal-exit":        true,
+	"terminal-closed":      true,
+	"terminal-oom":         true,
+	"limit-exceeded":       true,
+	"terminal-replay-done": true,
+	"workspace:commit":     true,
+}
+
+// commandEvents are the commands a frontend may send, as routed by
+// ws.Client.ReadPump, client-disconnected, which the bridge sends when one
+// leaves, and the other events the worker sends besides streamEvents and
+// broadcastEvents.
+var commandEvents = map[string]bool{
+	"init": true, "crud-read-file": true, "crud-read-folder": true, "create-terminal": true,
+	"attach-terminal": true, "close-terminal": true, "terminal-signal": true, "crud-download-workspace": true,
+	"hydrate-create-file": true, "crud-create-file": true, "crud-create-folder": true, "command-preview": true,
+	"command-run": true, "crud-update-file": true, "crud-patch-file": true, "crud-delete-resource": true,
+	"crud-move-resource": true, "crud-copy-resource": true, "system:checkout": true, "system:save-branch": true,
+	"exec": true, "replay-terminal": true, "cancel-replay": true, "crud-search": true, "cancel-search": true,
+	"crud-replace": true, "crud-find-files": true, "crud-stat": true, "crud-write-chunk": true,
+	"crud-list-trash": true, "crud-restore-resource": true, "terminal-input": true, "terminal-resize": true,
+	"crud-collapse-folder": true, "crud-close-file": true, "watch": true, "create-initial-commit": true,
+	"hydration-complete": true, "command-result-preview": true, "command-result-run": true,
+	"download-workspace": true, "add": true, "addDir": true, "change": true, "unlink": true,
+	"unlinkDir": true, "rename": true, "client-disconnected": true,
+}
+
+// EventLabel returns the metric label for an event name: the name itself for
+// a known command or worker event, else "unknown". Event names come from
+// clients, so using them unchecked would let any client add series.
+func EventLabel(event string) string {
+	if commandEvents[event] || streamEvents[event] || broadcastEvents[event] {
+		return event
+	}
+	return "unknown"
+}
+
 type Client struct {
 	conn     *websocket.Conn
 	mu       sync.Mutex
 	ackChans map[string]chan types.Acknowledge
+	pending  map[string]PendingAck // Debug info for ackChans, same keys
+	streams  map[string]func(msg *types.Message)
 	isReady  chan struct{}
 	send     chan *types.Message
 	eventBus *bus.EventBus
+	// workspaceID is stamped on every message sent to the worker for log correlation.
+	workspaceID string
+	hydration   HydrationStatus
+}
+
+// PendingAck describes a forwarded command still awaiting its acknowledgement.
+type PendingAck struct {
+	AckID    string    `json:"ackId"`
+	Event    string    `json:"event"`
+	ClientID string    `json:"clientId,omitempty"`
+	Since    time.Time `json:"since"`
+}
+
+// Hydration states reported by HydrationState.
+const (
+	HydrationPending    = "pending" // No client has initialized the workspace yet
+	HydrationSkipped    = "skipped" // DEV mode, nothing to hydrate
+	HydrationInProgress = "in_progress"
+	HydrationComplete   = "complete"
+	HydrationFailed     = "failed"
+)
+
+// HydrationStatus describes the most recent hydration attempt.
+type HydrationStatus struct {
+	State       string    `json:"state"`
+	Files       int       `json:"files"`
+	FailedFiles int       `json:"failedFiles"`
+	Bytes       int64     `json:"bytes"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"startedAt,omitzero"`
+	FinishedAt  time.Time `json:"finishedAt,omitzero"`
 }
 
 func GetInstance() *Client {
 	once.Do(func() {
 		client = &Client{
-			ackChans: make(map[string]chan types.Acknowledge),
-			eventBus: bus.GetInstance(),
-			send:     make(chan *types.Message, 256),
-			isReady:  make(chan struct{}),
+			ackChans:    make(map[string]chan types.Acknowledge),
+			pending:     make(map[string]PendingAck),
+			streams:     make(map[string]func(msg *types.Message)),
+			eventBus:    bus.GetInstance(),
+			send:        make(chan *types.Message, 256),
+			isReady:     make(chan struct{}),
+			workspaceID: os.Getenv("WORKSPACE_ID"),
+			hydration:   HydrationStatus{State: HydrationPending},
 		}
 		close(client.isReady)
 
+		metrics.NewGaugeFunc("bridge_pending_acks", "Commands forwarded to the worker that are still awaiting an acknowledgement.", func() float64 {
+			client.mu.Lock()
+			defer client.mu.Unlock()
+			return float64(len(client.ackChans))
+		})
+
 		// Start the single, lifelong writePump
 		go client.writePump()
 		// Start the single, lifelong connection supervisor
@@ -60,11 +169,11 @@ func (c *Client) supervisor() {
 	workerURL := url.URL{Scheme: "ws", Host: workerHost, Path: "/"}
 
 	for {
-		log.Println("BRIDGE: Attempting to connect to Worker...")
+		slog.Info("Attempting to connect to Worker", "url", workerURL.String())
 
 		conn, _, err := websocket.DefaultDialer.Dial(workerURL.String(), nil)
 		if err != nil {
-			log.Printf("BRIDGE: Worker connection failed: %v. Retrying in 5s...", err)
+			slog.Warn("Worker connection failed, retrying in 5s", "error", err)
 			time.Sleep(5 * time.Second)
 			continue // Retry connection loop
 		}
@@ -73,7 +182,8 @@ func (c *Client) supervisor() {
 		c.mu.Lock()
 		c.conn = conn
 		c.mu.Unlock()
-		log.Println("BRIDGE: ✅ Connected to Worker.")
+		workerConnected.Set(1)
+		slog.Info("Connected to Worker")
 
 		// Create a new channel to signal when THIS specific readPump is done.
 		readPumpDone := make(chan struct{})
@@ -91,7 +201,8 @@ func (c *Client) supervisor() {
 		// Wait here until the readPump for this connection exits.
 		// When it exits, it means the connection is lost.
 		<-readPumpDone
-		log.Println("BRIDGE: Disconnection detected. Restarting connection cycle.")
+		workerConnected.Set(0)
+		slog.Warn("Disconnection detected, restarting connection cycle")
 	}
 }
 
@@ -108,23 +219,38 @@ func (c *Client) readPump(done chan<- struct{}) {
 		var msg types.Message
 		err := c.conn.ReadJSON(&msg)
 		if err != nil {
-			log.Printf("BRIDGE: Error reading from Worker (disconnecting): %v", err)
+			slog.Error("Error reading from Worker, disconnecting", "error", err)
 			return // Exit to trigger the defer and signal disconnect.
 		}
 
-		log.Printf("[BRIDGE] Worker → Bridge: event=%s", msg.Event)
+		logger := messageLogger(&msg)
+		logger.Debug("Worker → Bridge")
+		workerMessages.WithLabelValues("in", EventLabel(msg.Event)).Inc()
 
-		if msg.Event == "file-changed" || msg.Event == "terminal-data" || msg.Event == "workspace:commit" {
-			log.Printf("[BRIDGE] Publishing event to EventBus: %s", msg.Event)
+		if broadcastEvents[msg.Event] {
+			logger.Debug("Publishing event to EventBus")
 			c.eventBus.Publish("worker.events", &msg)
 		}
 
+		if streamEvents[msg.Event] {
+			// Delivered from this loop, so it reaches the client before the ack.
+			ackID, _ := msg.Data.(map[string]interface{})["ackID"].(string)
+			c.mu.Lock()
+			onEvent := c.streams[ackID]
+			c.mu.Unlock()
+			if onEvent != nil {
+				onEvent(&msg)
+			}
+			continue
+		}
+
 		if ackID, ok := msg.Data.(map[string]interface{})["ackID"].(string); ok {
 			c.mu.Lock()
 			if ch, exists := c.ackChans[ackID]; exists {
-				log.Printf("[BRIDGE] Resolving ackID=%s for event=%s", ackID, msg.Event)
+				logger.Debug("Resolving ack")
 				ch <- types.Acknowledge{Event: msg.Event, Data: msg.Data}
 				delete(c.ackChans, ackID)
+				delete(c.pending, ackID)
 			}
 			c.mu.Unlock()
 		}
@@ -135,31 +261,48 @@ func (c *Client) writePump() {
 	for msg := range c.send {
 		c.mu.Lock()
 		if c.conn == nil {
-			log.Printf("BRIDGE: writePump trying to write event '%s', but connection is nil. Skipping.", msg.Event)
+			messageLogger(msg).Warn("Worker connection is nil, skipping write")
 			c.mu.Unlock()
 			continue
 		}
-		log.Printf("[BRIDGE] Bridge → Worker: event=%s", msg.Event)
+		messageLogger(msg).Debug("Bridge → Worker")
 		err := c.conn.WriteJSON(msg)
 		c.mu.Unlock()
 
 		if err != nil {
-			log.Printf("BRIDGE: Error writing to Worker: %v", err)
+			messageLogger(msg).Error("Error writing to Worker", "error", err)
+			continue
 		}
+		workerMessages.WithLabelValues("out", EventLabel(msg.Event)).Inc()
 	}
 }
 
-func (c *Client) ForwardCommand(msg *types.Message, ackID string) (types.Acknowledge, error) {
+func (c *Client) ForwardCommand(ctx context.Context, msg *types.Message, ackID string) (types.Acknowledge, error) {
+	return c.forward(ctx, msg, ackID, nil)
+}
+
+// forward sends msg to the worker and waits for its acknowledgement. Each
+// signal on activity restarts the wait, so a command that is still streaming
+// events is not timed out.
+func (c *Client) forward(ctx context.Context, msg *types.Message, ackID string, activity <-chan struct{}) (ack types.Acknowledge, err error) {
+	ctx, span := tracing.Start(ctx, "bridge.ForwardCommand", "event", msg.Event, "ackId", ackID)
+	defer span.EndErr(&err)
+
 	select {
 	case <-c.isReady:
 		// Connection is ready, proceed.
 	default:
-		return types.Acknowledge{}, fmt.Errorf("BRIDGE: Connection not ready for event %s", msg.Event)
+		ackLatency.WithLabelValues(EventLabel(msg.Event), "not_ready").Observe(0)
+		return types.Acknowledge{}, fmt.Errorf("connection not ready for event %s", msg.Event)
 	}
 
+	msg.Meta = c.stampMeta(ctx, msg.Meta)
+	msg.Meta.AckID = ackID
+
 	c.mu.Lock()
 	ackChan := make(chan types.Acknowledge, 1)
 	c.ackChans[ackID] = ackChan
+	c.pending[ackID] = PendingAck{AckID: ackID, Event: msg.Event, ClientID: msg.Meta.ClientID, Since: time.Now()}
 	c.mu.Unlock()
 
 	// Add the internal ackID to the message payload.
@@ -168,54 +311,210 @@ func (c *Client) ForwardCommand(msg *types.Message, ackID string) (types.Acknowl
 		msg.Data = data
 	}
 
+	start := time.Now()
 	c.send <- msg
 
-	select {
-	case ack := <-ackChan:
-		return ack, nil
-	case <-time.After(10 * time.Second):
+	timeout := ackTimeout(msg)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case ack := <-ackChan:
+			ackLatency.WithLabelValues(EventLabel(msg.Event), "ok").Observe(time.Since(start).Seconds())
+			return ack, nil
+		case <-activity:
+			timer.Reset(timeout)
+		case <-timer.C:
+			c.mu.Lock()
+			delete(c.ackChans, ackID)
+			delete(c.pending, ackID)
+			c.mu.Unlock()
+			ackLatency.WithLabelValues(EventLabel(msg.Event), "timeout").Observe(time.Since(start).Seconds())
+			return types.Acknowledge{}, fmt.Errorf("acknowledgement timeout for event %s", msg.Event)
+		}
+	}
+}
+
+const (
+	defaultAckTimeout = 10 * time.Second
+	// The worker's default and maximum exec timeouts.
+	defaultExecTimeout = 60 * time.Second
+	maxExecTimeout     = 10 * time.Minute
+	// The worker's time limit for a whole search.
+	searchTimeout = 30 * time.Second
+)
+
+// ackTimeout is how long to wait for the acknowledgement of msg. An exec is
+// only acknowledged once its command finishes, so it gets the command's own
+// timeout plus a margin for the worker to report it; a search or replace
+// likewise. A streamed read has no fixed length, so for it this is the
+// longest wait between chunks.
+func ackTimeout(msg *types.Message) time.Duration {
+	switch msg.Event {
+	case "exec":
+	case "crud-search", "crud-replace":
+		return searchTimeout + defaultAckTimeout
+	default:
+		return defaultAckTimeout
+	}
+	timeout := defaultExecTimeout
+	if data, ok := msg.Data.(map[string]interface{}); ok {
+		if ms, ok := data["timeoutMs"].(float64); ok && ms > 0 {
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+	return min(timeout, maxExecTimeout) + defaultAckTimeout
+}
+
+// ForwardCommandStream is ForwardCommand for commands that stream events
+// before their acknowledgement. onEvent is called from the worker read loop,
+// so it may wait only briefly. The acknowledgement timeout restarts with
+// every event.
+func (c *Client) ForwardCommandStream(ctx context.Context, msg *types.Message, ackID string, onEvent func(msg *types.Message)) (types.Acknowledge, error) {
+	activity := make(chan struct{}, 1)
+	c.mu.Lock()
+	c.streams[ackID] = func(msg *types.Message) {
+		onEvent(msg)
+		select {
+		case activity <- struct{}{}:
+		default:
+		}
+	}
+	c.mu.Unlock()
+	defer func() {
 		c.mu.Lock()
-		delete(c.ackChans, ackID)
+		delete(c.streams, ackID)
 		c.mu.Unlock()
-		return types.Acknowledge{}, log.Output(1, "BRIDGE: Acknowledgement timeout")
+	}()
+	return c.forward(ctx, msg, ackID, activity)
+}
+
+// stampMeta fills in the correlation fields the bridge owns, keeping any
+// client ID set by the frontend-facing side. The trace parent is taken from
+// ctx so that worker spans become children of the caller's span.
+func (c *Client) stampMeta(ctx context.Context, meta *types.Meta) *types.Meta {
+	stamped := types.Meta{}
+	if meta != nil {
+		stamped = *meta
+	}
+	stamped.WorkspaceID = c.workspaceID
+	stamped.TraceParent = tracing.TraceParentFromContext(ctx)
+	return &stamped
+}
+
+// messageLogger returns a logger carrying the correlation fields of msg.
+func messageLogger(msg *types.Message) *slog.Logger {
+	logger := slog.With(logging.KeyEvent, msg.Event)
+	if msg.Meta != nil {
+		if msg.Meta.ClientID != "" {
+			logger = logger.With(logging.KeyClientID, msg.Meta.ClientID)
+		}
+		if msg.Meta.AckID != "" {
+			logger = logger.With(logging.KeyAckID, msg.Meta.AckID)
+		}
+		if sc, ok := tracing.ParseTraceParent(msg.Meta.TraceParent); ok {
+			logger = logger.With(logging.KeyTraceID, sc.TraceID.String())
+		}
 	}
+	return logger
 }
 
-func (c *Client) SendFireAndForget(msg *types.Message) {
+func (c *Client) SendFireAndForget(ctx context.Context, msg *types.Message) {
 	select {
 	case <-c.isReady:
 		// Connection is ready, proceed.
 	default:
 		// Silently drop if not ready, or log a warning.
-		log.Printf("BRIDGE: Connection not ready, dropping fire-and-forget event %s", msg.Event)
+		messageLogger(msg).Warn("Connection not ready, dropping fire-and-forget event")
 		return
 	}
+	msg.Meta = c.stampMeta(ctx, msg.Meta)
 	c.send <- msg
 }
 
-func (c *Client) TriggerHydration() {
+// IsConnected reports whether the bridge currently holds a worker connection.
+func (c *Client) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// PendingAcks lists forwarded commands still awaiting acknowledgement, oldest first.
+func (c *Client) PendingAcks() []PendingAck {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	acks := make([]PendingAck, 0, len(c.pending))
+	for _, p := range c.pending {
+		acks = append(acks, p)
+	}
+	sort.Slice(acks, func(i, j int) bool { return acks[i].Since.Before(acks[j].Since) })
+	return acks
+}
+
+// Hydration returns a snapshot of the most recent hydration attempt.
+func (c *Client) Hydration() HydrationStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hydration
+}
+
+func (c *Client) updateHydration(update func(h *HydrationStatus)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	update(&c.hydration)
+}
+
+// StartHydration claims the next hydration, marking it in progress, and
+// reports false if one is in progress already. Only the caller it returns
+// true to may then run TriggerHydration.
+func (c *Client) StartHydration() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hydration.State == HydrationInProgress {
+		return false
+	}
+	c.hydration = HydrationStatus{State: HydrationInProgress, StartedAt: time.Now()}
+	return true
+}
+
+// TriggerHydration runs a hydration claimed with StartHydration.
+func (c *Client) TriggerHydration(ctx context.Context) {
 	// Skip hydration in development mode
 	env := os.Getenv("ENV")
 	if env != "DEV" {
-		c.hydrateWorkspace()
+		c.hydrateWorkspace(ctx)
 	} else {
-		log.Println("[BRIDGE] DEV mode detected - skipping workspace hydration")
+		c.updateHydration(func(h *HydrationStatus) { h.State = HydrationSkipped })
+		slog.Info("DEV mode detected, skipping workspace hydration")
 	}
 }
 
-func (c *Client) hydrateWorkspace() {
+func (c *Client) hydrateWorkspace(ctx context.Context) {
 	// 1. Get Workspace ID from the environment variable.
 	workspaceID := os.Getenv("WORKSPACE_ID")
 	if workspaceID == "" {
 		workspaceID = "demo"
-		log.Printf("[BRIDGE] WARNING - WORKSPACE_ID not set, falling back to default for hydration: %s", workspaceID)
+		slog.Warn("WORKSPACE_ID not set, falling back to default for hydration", logging.KeyWorkspaceID, workspaceID)
 	}
 
-	log.Printf("[BRIDGE] Starting workspace hydration for %s...", workspaceID)
+	ctx, span := tracing.Start(ctx, "bridge.hydrate", "workspaceId", workspaceID)
+	defer span.End()
+	logger := slog.With(logging.KeyWorkspaceID, workspaceID, logging.KeyTraceID, tracing.TraceIDFromContext(ctx))
+	logger.Info("Starting workspace hydration")
+	start := c.Hydration().StartedAt
+	defer func() {
+		hydrationDuration.Observe(time.Since(start).Seconds())
+	}()
 
 	minioClient, err := minioClient.NewClient()
 	if err != nil {
-		log.Printf("[BRIDGE] ⛔ HYDRATION FAILED (MinIO connect): %v", err)
+		logger.Error("Hydration failed: could not connect to MinIO", "error", err)
+		span.RecordError(err)
+		c.updateHydration(func(h *HydrationStatus) {
+			h.State = HydrationFailed
+			h.Error = err.Error()
+			h.FinishedAt = time.Now()
+		})
 		return
 	}
 
@@ -229,11 +528,14 @@ func (c *Client) hydrateWorkspace() {
 	})
 
 	var wg sync.WaitGroup
-	log.Printf("[BRIDGE] Hydrating from s3://%s/%s", bucketName, s3Path)
+	logger.Info("Hydrating from object storage", "bucket", bucketName, "prefix", s3Path)
 
 	for object := range objectCh {
 		if object.Err != nil {
-			log.Printf("[MINIO] Error listing object: %v", object.Err)
+			logger.Error("Error listing object", "error", object.Err)
+			c.updateHydration(func(h *HydrationStatus) {
+				h.Error = object.Err.Error()
+			})
 			continue
 		}
 		if strings.HasSuffix(object.Key, "/") {
@@ -246,15 +548,25 @@ func (c *Client) hydrateWorkspace() {
 
 			object, err := minioClient.GetObject(context.Background(), bucketName, objKey, minio.GetObjectOptions{})
 			if err != nil {
-				log.Printf("Failed to get object %s: %v", objKey, err)
+				logger.Error("Failed to get object", "key", objKey, "error", err)
+				hydrationFiles.WithLabelValues("error").Inc()
+				c.updateHydration(func(h *HydrationStatus) { h.FailedFiles++ })
 				return
 			}
 
 			contentBytes, err := io.ReadAll(object)
 			if err != nil {
-				log.Printf("Failed to read object %s: %v", objKey, err)
+				logger.Error("Failed to read object", "key", objKey, "error", err)
+				hydrationFiles.WithLabelValues("error").Inc()
+				c.updateHydration(func(h *HydrationStatus) { h.FailedFiles++ })
 				return
 			}
+			hydrationBytes.Add(float64(len(contentBytes)))
+			hydrationFiles.WithLabelValues("ok").Inc()
+			c.updateHydration(func(h *HydrationStatus) {
+				h.Files++
+				h.Bytes += int64(len(contentBytes))
+			})
 
 			contentBase64 := base64.StdEncoding.EncodeToString(contentBytes)
 			relativePath := strings.Replace(objKey, s3Path, "/workspace", 1)
@@ -267,15 +579,23 @@ func (c *Client) hydrateWorkspace() {
 				},
 			}
 
-			c.SendFireAndForget(hydrateMsg)
+			c.SendFireAndForget(ctx, hydrateMsg)
 		}(object.Key)
 	}
 
 	wg.Wait()
-	log.Println("[BRIDGE] ✅ Workspace hydration complete.")
+	c.updateHydration(func(h *HydrationStatus) {
+		h.FinishedAt = time.Now()
+		if h.FailedFiles > 0 || h.Error != "" {
+			h.State = HydrationFailed
+		} else {
+			h.State = HydrationComplete
+		}
+	})
+	logger.Info("Workspace hydration complete", "duration", time.Since(start))
 
 	// Notify frontend that hydration is complete
-	c.SendFireAndForget(&types.Message{
+	c.SendFireAndForget(ctx, &types.Message{
 		Event: "hydration-complete",
 		Data:  map[string]interface{}{},
 	})
@@ -1,26 +1,54 @@
 package ws
 
 import (
+	"bridge/internal/logging"
+	"bridge/internal/metrics"
+	"bridge/internal/tracing"
 	"bridge/internal/worker"
 	"bridge/pkg/types"
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+var frontendMessages = metrics.NewCounterVec("bridge_frontend_messages_total", "Messages exchanged with frontend clients, by direction and event type.", "direction", "event")
+
 type Client struct {
 	ID     string
 	Hub    *Hub
 	Conn   *websocket.Conn
 	Send   chan *types.Message
 	Worker *worker.Client
+	logger *slog.Logger
+
+	connectedAt time.Time
+	mu          sync.Mutex
+	role        string // Mode from the client's init message
+}
+
+// Role returns the mode the client declared in init, or "" before init.
+func (c *Client) Role() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.role
 }
 
 func (c *Client) ReadPump() {
 	defer func() {
 		c.Hub.Unregister <- c
 		c.Conn.Close()
+		// Let the worker stop what the client left running, such as execs.
+		c.Worker.SendFireAndForget(context.Background(), &types.Message{
+			Event: "client-disconnected",
+			Data:  map[string]interface{}{},
+			Meta:  &types.Meta{ClientID: c.ID},
+		})
 	}()
 
 	for {
@@ -28,36 +56,64 @@ func (c *Client) ReadPump() {
 		err := c.Conn.ReadJSON(&msg)
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("BRIDGE: read error: %v", err)
+				c.logger.Warn("Read error", "error", err)
 			}
 			break
 		}
+		frontendMessages.WithLabelValues("in", worker.EventLabel(msg.Event)).Inc()
+
+		// The frontend may start a trace and pass it along in the envelope.
+		ctx := context.Background()
+		if msg.Meta != nil {
+			ctx = tracing.ContextWithRemoteParent(ctx, msg.Meta.TraceParent)
+		}
+		ctx, span := tracing.Start(ctx, "bridge.route", "event", msg.Event, "clientId", c.ID)
+		msg.Meta = &types.Meta{ClientID: c.ID}
+		logger := c.logger.With(logging.KeyEvent, msg.Event, logging.KeyTraceID, tracing.TraceIDFromContext(ctx))
 
 		switch msg.Event {
 		// Special handling for init - forward to worker and trigger hydration
 		case "init":
-			log.Printf("[BRIDGE] Frontend → Worker (init): event=%s, data=%v", msg.Event, msg.Data)
+			logger.Info("Frontend → Worker (init)", "data", msg.Data)
+			if data, ok := msg.Data.(map[string]interface{}); ok {
+				if mode, ok := data["mode"].(string); ok {
+					c.mu.Lock()
+					c.role = mode
+					c.mu.Unlock()
+				}
+			}
 			// Forward init message to worker (contains mode from frontend)
-			c.Worker.SendFireAndForget(&msg)
-			// Trigger hydration after sending init
-			go c.Worker.TriggerHydration()
+			c.Worker.SendFireAndForget(ctx, &msg)
+			// Trigger hydration after sending init, unless one is running
+			if c.Worker.StartHydration() {
+				go c.Worker.TriggerHydration(ctx)
+			} else {
+				logger.Debug("Hydration already in progress")
+			}
+			span.End()
 
 		// Events that require request-response pattern
-		case "crud-read-file", "crud-read-folder", "create-terminal", "close-terminal", "crud-download-workspace",
+		case "crud-read-file", "crud-read-folder", "create-terminal", "attach-terminal", "close-terminal", "terminal-signal", "crud-download-workspace",
 			"hydrate-create-file", "crud-create-file", "crud-create-folder", "command-preview", "command-run",
-			"crud-update-file", "crud-delete-resource", "crud-move-resource",
-			"system:checkout", "system:save-branch":
-			log.Printf("[BRIDGE] Frontend → Worker (request-response): event=%s", msg.Event)
-			go c.handleRequestResponse(msg)
+			"crud-update-file", "crud-patch-file", "crud-delete-resource", "crud-move-resource", "crud-copy-resource",
+			"system:checkout", "system:save-branch", "exec", "replay-terminal", "cancel-replay",
+			"crud-search", "cancel-search", "crud-replace", "crud-find-files", "crud-stat", "crud-write-chunk",
+			"crud-list-trash", "crud-restore-resource":
+			logger.Debug("Frontend → Worker (request-response)")
+			// The route span ends once the acknowledgement has been relayed.
+			go c.handleRequestResponse(ctx, span, msg)
 
 		// Fire-and-forget events (no response expected from worker)
-		case "terminal-input", "crud-collapse-folder", "crud-close-file", "watch", "create-initial-commit":
-			log.Printf("[BRIDGE] Frontend → Worker (fire-and-forget): event=%s", msg.Event)
+		case "terminal-input", "terminal-resize", "crud-collapse-folder", "crud-close-file", "watch", "create-initial-commit":
+			logger.Debug("Frontend → Worker (fire-and-forget)")
 			// No response needed, just forward to the worker.
-			c.Worker.SendFireAndForget(&msg)
+			c.Worker.SendFireAndForget(ctx, &msg)
+			span.End()
 
 		default:
-			log.Printf("BRIDGE: Received unknown event type from client: %s", msg.Event)
+			logger.Warn("Received unknown event type from client")
+			span.SetAttributes("unknown", true)
+			span.End()
 		}
 	}
 }
@@ -70,31 +126,140 @@ func (c *Client) WritePump() {
 			c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 			return
 		}
-		log.Printf("[BRIDGE] Worker → Frontend: event=%s", message.Event)
+		c.logger.Debug("Worker → Frontend", logging.KeyEvent, message.Event)
+		frontendMessages.WithLabelValues("out", worker.EventLabel(message.Event)).Inc()
 		c.Conn.WriteJSON(message)
 	}
 }
 
-func (c *Client) handleRequestResponse(msg types.Message) {
+// streamingCommands may send events ahead of their acknowledgement, which are
+// relayed to the requesting client only.
+var streamingCommands = map[string]bool{
+	"exec":           true,
+	"crud-search":    true,
+	"crud-read-file": true,
+}
+
+func (c *Client) handleRequestResponse(ctx context.Context, span *tracing.Span, msg types.Message) {
+	defer span.End()
+
 	// The frontend is responsible for generating and tracking its own internalAckID.
 	internalAckID := uuid.New().String()
+	span.SetAttributes("ackId", internalAckID)
 
 	// Forward the command to the worker and wait for its acknowledgement.
-	ack, err := c.Worker.ForwardCommand(&msg, internalAckID)
+	logger := c.logger.With(logging.KeyEvent, msg.Event, logging.KeyAckID, internalAckID, logging.KeyTraceID, tracing.TraceIDFromContext(ctx))
+	var ack types.Acknowledge
+	var err error
+	var stream *streamRelay
+	if streamingCommands[msg.Event] {
+		stream = &streamRelay{client: c}
+		ack, err = c.Worker.ForwardCommandStream(ctx, &msg, internalAckID, stream.relay)
+	} else {
+		ack, err = c.Worker.ForwardCommand(ctx, &msg, internalAckID)
+	}
 	if err != nil {
-		log.Printf("BRIDGE: Error forwarding command '%s': %v", msg.Event, err)
-		// Use Send channel instead of direct WriteJSON to avoid concurrent writes
-		c.Send <- &types.Message{
+		logger.Error("Error forwarding command", "error", err)
+		span.RecordError(err)
+		c.reply(logger, &types.Message{
 			Event: msg.Event,
 			Data:  map[string]interface{}{"ackID": internalAckID, "error": err.Error()},
-		}
+		})
 		return
 	}
-	log.Printf("BRIDGE: Send forwarding command '%s': %v", msg.Event, err)
+	logger.Debug("Forwarded command acknowledged")
+	if dropped := stream.droppedEvents(); dropped > 0 {
+		logger.Warn("Client fell behind, streamed events were not delivered", "dropped", dropped)
+		markIncomplete(msg.Event, ack.Data, dropped)
+	}
 
-	// Use Send channel instead of direct WriteJSON to avoid concurrent writes
-	c.Send <- &types.Message{
+	c.reply(logger, &types.Message{
 		Event: ack.Event,
 		Data:  ack.Data,
+	})
+}
+
+// reply sends the answer to a command through the Send channel, which keeps
+// writes to the connection serial. A command can outlast its client, whose
+// Send the hub has closed by then, so the answer goes through deliver and is
+// dropped if the client is gone.
+func (c *Client) reply(logger *slog.Logger, msg *types.Message) {
+	if !c.deliver(msg, relayTimeout) {
+		logger.Warn("Client left or fell behind, reply not delivered")
+	}
+}
+
+// relayTimeout bounds how long relaying a streamed event waits for room in
+// the client's send buffer. The worker read loop does the waiting, so a
+// client that stops reading fails its own stream rather than stalling every
+// other client for long.
+const (
+	relayTimeout     = 5 * time.Second
+	relayRetryPeriod = 10 * time.Millisecond
+)
+
+// streamRelay passes the streamed events of one command to the client in
+// order, waiting for room rather than dropping any. Once an event cannot be
+// delivered the stream has failed: the rest are discarded and counted, and
+// the acknowledgement says so.
+type streamRelay struct {
+	client  *Client
+	dropped atomic.Int64
+}
+
+func (r *streamRelay) relay(msg *types.Message) {
+	if r.dropped.Load() > 0 || !r.client.deliver(msg, relayTimeout) {
+		r.dropped.Add(1)
+	}
+}
+
+// droppedEvents returns how many events were not delivered; none for a
+// command that does not stream.
+func (r *streamRelay) droppedEvents() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.dropped.Load()
+}
+
+// markIncomplete records in the acknowledgement data of a streamed command
+// that some of its events were lost. A file read from incomplete chunks
+// would be corrupt, so it fails; exec output and search results are
+// flagged as truncated.
+func markIncomplete(event string, ackData interface{}, dropped int64) {
+	data, ok := ackData.(map[string]interface{})
+	if !ok {
+		return
 	}
-}
\ No newline at end of file
+	data["truncated"] = true
+	data["dropped"] = dropped
+	if event == "crud-read-file" {
+		data["error"] = fmt.Sprintf("stream interrupted: %d chunks could not be delivered, read the file again", dropped)
+	}
+}
+
+// deliver queues msg for this client, waiting up to timeout for room in its
+// send buffer. It reports false if the client left or stayed full.
+func (c *Client) deliver(msg *types.Message, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		// Holding the lock keeps the hub from closing Send under us. It is
+		// released between attempts so the hub is never held up for long.
+		c.Hub.mu.RLock()
+		if !c.Hub.Clients[c] {
+			c.Hub.mu.RUnlock()
+			return false
+		}
+		select {
+		case c.Send <- msg:
+			c.Hub.mu.RUnlock()
+			return true
+		default:
+		}
+		c.Hub.mu.RUnlock()
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(relayRetryPeriod)
+	}
+}
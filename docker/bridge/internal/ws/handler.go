@@ -1,9 +1,11 @@
 package ws
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"bridge/internal/logging"
 	"bridge/internal/worker"
 	"bridge/pkg/types"
 
@@ -24,18 +26,22 @@ var (
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		slog.Warn("WebSocket upgrade failed", "remoteAddr", r.RemoteAddr, "error", err)
 		return
 	}
 
 	workerClient := worker.GetInstance()
 
+	clientID := uuid.New().String()
 	client := &Client{
-		ID:     uuid.New().String(),
+		ID:     clientID,
 		Hub:    hub,
 		Conn:   conn,
 		Send:   make(chan *types.Message, 256),
 		Worker: workerClient,
+		logger: slog.With(logging.KeyClientID, clientID),
+
+		connectedAt: time.Now(),
 	}
 	client.Hub.Register <- client
 
@@ -2,16 +2,29 @@ package ws
 
 import (
 	"bridge/internal/bus"
+	"bridge/internal/metrics"
 	"bridge/pkg/types"
-	"log"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
+var connectedClients = metrics.NewGauge("bridge_connected_clients", "Number of frontend clients connected to the bridge.")
+
 type Hub struct {
 	Clients map[*Client]bool
 	Broadcast chan *types.Message
 	Register chan *Client
 	Unregister chan *Client
 	eventBus *bus.EventBus
+	// mu guards Clients. Run is the only writer; readers are the admin API
+	// and Client.deliver. A client is removed under mu before its Send is
+	// closed, so whoever finds it in Clients under mu may send to it.
+	mu sync.RWMutex
 }
 
 func NewHub() *Hub {
@@ -31,35 +44,113 @@ func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.Register:
+			h.mu.Lock()
 			h.Clients[client] = true
-			log.Printf("BRIDGE: Client %s registered to hub", client.ID)
+			h.mu.Unlock()
+			connectedClients.Inc()
+			client.logger.Info("Client registered to hub", "clients", len(h.Clients))
 
 		case client := <-h.Unregister:
 			if _, ok := h.Clients[client]; ok {
+				h.mu.Lock()
 				delete(h.Clients, client)
+				h.mu.Unlock()
 				close(client.Send)
-				log.Printf("BRIDGE: Client %s unregistered from hub", client.ID)
+				connectedClients.Dec()
+				client.logger.Info("Client unregistered from hub", "clients", len(h.Clients))
 			}
 
 		case message := <-h.Broadcast:
-			for client := range h.Clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.Clients, client)
-				}
-			}
+			h.broadcast(message)
 
 		case message := <-workerEvents:
-			for client := range h.Clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.Clients, client)
-				}
-			}
+			h.broadcast(message)
+		}
+	}
+}
+
+func (h *Hub) broadcast(message *types.Message) {
+	for client := range h.Clients {
+		select {
+		case client.Send <- message:
+		default:
+			h.mu.Lock()
+			delete(h.Clients, client)
+			h.mu.Unlock()
+			close(client.Send)
+			connectedClients.Dec()
+			client.logger.Warn("Client send buffer full, dropping client")
 		}
 	}
 }
+
+// ClientInfo describes a connected frontend client, for debugging.
+type ClientInfo struct {
+	ID          string    `json:"id"`
+	Role        string    `json:"role"` // Mode sent in init (RECORDING, PLAYBACK), empty before init
+	RemoteAddr  string    `json:"remoteAddr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	Buffered    int       `json:"buffered"` // Messages waiting in the client's send channel
+}
+
+// ListClients returns the connected clients, oldest first.
+func (h *Hub) ListClients() []ClientInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	infos := make([]ClientInfo, 0, len(h.Clients))
+	for client := range h.Clients {
+		infos = append(infos, ClientInfo{
+			ID:          client.ID,
+			Role:        client.Role(),
+			RemoteAddr:  client.Conn.RemoteAddr().String(),
+			ConnectedAt: client.connectedAt,
+			Buffered:    len(client.Send),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ConnectedAt.Before(infos[j].ConnectedAt) })
+	return infos
+}
+
+// ErrUnknownClient is returned by Kick for an ID that is not connected.
+var ErrUnknownClient = errors.New("unknown client")
+
+// kickTimeout bounds how long Kick waits for a kicked client to unregister.
+const kickTimeout = 5 * time.Second
+
+// Kick closes the connection of the client with the given ID. The client's
+// ReadPump then unregisters it as for any other disconnect, which Kick waits
+// for.
+func (h *Hub) Kick(id string, reason string) error {
+	h.mu.RLock()
+	var target *Client
+	for client := range h.Clients {
+		if client.ID == id {
+			target = client
+			break
+		}
+	}
+	h.mu.RUnlock()
+	if target == nil {
+		return ErrUnknownClient
+	}
+
+	target.logger.Warn("Kicking client", "reason", reason)
+	closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	target.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+	target.Conn.Close()
+
+	deadline := time.Now().Add(kickTimeout)
+	for h.registered(target) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("client %s still connected after %s", id, kickTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func (h *Hub) registered(client *Client) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.Clients[client]
+}
@@ -4,6 +4,17 @@ package types
 type Message struct {
 	Event string      `json:"event"`
 	Data  interface{} `json:"data"`
+	Meta  *Meta       `json:"meta,omitempty"`
+}
+
+// Meta carries correlation identifiers across the bridge → worker hop.
+// The bridge fills it in; the worker echoes it back on replies.
+type Meta struct {
+	WorkspaceID string `json:"workspaceId,omitempty"`
+	ClientID    string `json:"clientId,omitempty"`
+	AckID       string `json:"ackId,omitempty"`
+	// TraceParent is a W3C traceparent value linking spans across services.
+	TraceParent string `json:"traceparent,omitempty"`
 }
 
 // Acknowledge represents a generic response structure for command acknowledgements.
@@ -1,24 +1,64 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"worker/internal/cgroup"
 	"worker/internal/filesystem"
+	"worker/internal/health"
+	"worker/internal/logging"
+	"worker/internal/metrics"
+	"worker/internal/process"
+	"worker/internal/sandbox"
 	"worker/internal/terminal"
+	"worker/internal/tracing"
 	"worker/internal/watcher"
 	"worker/internal/ws"
 
 	"github.com/joho/godotenv"
 )
 
+// shutdownTimeout bounds how long a signalled shutdown waits for requests in
+// flight.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	// Load environment variables from .env file
-	if err := godotenv.Load(); err != nil {
-		log.Printf("WORKER: Warning - Error loading .env file: %v", err)
+	// The worker binary doubles as the init process of sandboxed terminals.
+	if sandbox.IsHelper() {
+		sandbox.Main()
+	}
+
+	// Load environment variables from .env file before configuring logging,
+	// so LOG_LEVEL and friends can be set there.
+	envErr := godotenv.Load()
+	logging.Init("worker")
+	if envErr != nil {
+		slog.Warn("Error loading .env file", "error", envErr)
 	} else {
-		log.Println("WORKER: Environment variables loaded from .env file")
+		slog.Info("Environment variables loaded from .env file")
+	}
+
+	traceExporter, err := tracing.Init("worker")
+	if err != nil {
+		slog.Error("Failed to configure tracing, spans will be dropped", "error", err)
+	}
+	// os.Exit skips deferred calls, so main leaves through exit, which flushes
+	// the traces first.
+	exit := func(code int) {
+		if traceExporter != nil {
+			traceExporter.Shutdown()
+		}
+		os.Exit(code)
 	}
 
 	workspaceDir := os.Getenv("WORKER_WORKSPACE_DIR")
@@ -28,27 +68,111 @@ func main() {
 		// In DEV mode, use the project's workspace folder directly
 		if env == "DEV" {
 			workspaceDir = "/workspace"
-			log.Printf("WORKER: DEV mode - using local workspace directory: %s", workspaceDir)
+			slog.Info("DEV mode, using local workspace directory", "dir", workspaceDir)
 		} else {
 			workspaceDir = "/workspace"
-			log.Printf("WORKER: WARNING - WORKER_WORKSPACE_DIR not set, falling back to default: %s", workspaceDir)
+			slog.Warn("WORKER_WORKSPACE_DIR not set, falling back to default", "dir", workspaceDir)
 		}
 	}
 	if err := os.MkdirAll(workspaceDir, os.ModePerm); err != nil {
-		log.Fatalf("WORKER: Could not create workspace directory %s: %v", workspaceDir, err)
+		slog.Error("Could not create workspace directory", "dir", workspaceDir, "error", err)
+		exit(1)
 	}
 
+	// Worker-owned files (recordings, staged uploads, trash) live beside the
+	// workspace, not in it, so they are neither persisted with it nor visible
+	// in the file tree.
+	dataDir := os.Getenv("WORKER_DATA_DIR")
+	if dataDir == "" {
+		dataDir = filepath.Join(filepath.Dir(filepath.Clean(workspaceDir)), ".room-data")
+	}
+
+	// Reap processes orphaned by terminal shells (the worker is PID 1 in its container).
+	process.StartReaper(10 * time.Second)
+
 	hub := ws.NewHub()
 	go hub.Run()
 
 	fsSvc := filesystem.NewService(workspaceDir)
-	termSvc := terminal.NewService(workspaceDir)
+	fsSvc.SetDataDir(dataDir)
+	if v, err := strconv.ParseInt(os.Getenv("WORKER_MAX_INLINE_FILE_BYTES"), 10, 64); err == nil {
+		fsSvc.SetMaxInlineSize(v)
+	}
+	if v, err := strconv.ParseInt(os.Getenv("WORKER_MAX_UPLOAD_BYTES"), 10, 64); err == nil {
+		fsSvc.SetMaxUploadSize(v)
+	}
+	var trashLimits filesystem.TrashLimits
+	trashLimits.MaxItems, _ = strconv.Atoi(os.Getenv("WORKER_TRASH_MAX_ITEMS"))
+	trashLimits.MaxAge, _ = time.ParseDuration(os.Getenv("WORKER_TRASH_MAX_AGE"))
+	trashLimits.MaxBytes, _ = strconv.ParseInt(os.Getenv("WORKER_TRASH_MAX_BYTES"), 10, 64)
+	trashLimits.MaxItemBytes, _ = strconv.ParseInt(os.Getenv("WORKER_TRASH_MAX_ITEM_BYTES"), 10, 64)
+	fsSvc.SetTrashLimits(trashLimits)
+	termSvc := terminal.NewService(workspaceDir, hub.Send)
+	if shells := os.Getenv("WORKER_ALLOWED_SHELLS"); shells != "" {
+		termSvc.SetAllowedShells(strings.Split(shells, ","))
+	}
+	if os.Getenv("WORKER_CGROUPS") != "off" {
+		// Defaults leave headroom for the worker in a 1Gi pod.
+		limits := cgroup.Limits{MemoryMax: 768 << 20, CPUs: 1, PidsMax: 512}
+		if v, err := strconv.ParseInt(os.Getenv("WORKER_TERMINAL_MEMORY_MAX_BYTES"), 10, 64); err == nil {
+			limits.MemoryMax = v
+		}
+		if v, err := strconv.ParseFloat(os.Getenv("WORKER_TERMINAL_CPUS"), 64); err == nil {
+			limits.CPUs = v
+		}
+		if v, err := strconv.ParseInt(os.Getenv("WORKER_TERMINAL_PIDS_MAX"), 10, 64); err == nil {
+			limits.PidsMax = v
+		}
+		if cgroups, err := cgroup.Setup(limits); err != nil {
+			slog.Warn("cgroup v2 unavailable, terminals run without resource limits", "error", err)
+		} else {
+			termSvc.SetCgroups(cgroups)
+		}
+	}
+	if os.Getenv("WORKER_RECORD_TERMINALS") == "on" {
+		recordingsDir := filepath.Join(dataDir, "recordings")
+		if err := os.MkdirAll(recordingsDir, 0755); err != nil {
+			slog.Error("Could not create recordings directory, terminals will not be recorded", "dir", recordingsDir, "error", err)
+		} else {
+			termSvc.SetRecording(recordingsDir)
+			slog.Info("Recording terminals", "dir", recordingsDir)
+		}
+	}
+	if os.Getenv("WORKER_SANDBOX") == "on" {
+		absWorkspace, err := filepath.Abs(workspaceDir)
+		if err != nil {
+			slog.Error("Could not resolve workspace directory", "dir", workspaceDir, "error", err)
+			exit(1)
+		}
+		cfg := &sandbox.Config{Workspace: absWorkspace, Network: os.Getenv("WORKER_SANDBOX_NETWORK") != "off"}
+		// Fail closed: an operator who asked for the sandbox must not get
+		// unconfined terminals.
+		if err := cfg.Probe(); err != nil {
+			slog.Error("Terminal sandbox is unavailable on this host", "error", err)
+			exit(1)
+		}
+		termSvc.SetSandbox(cfg)
+		slog.Info("Terminals run in a namespace sandbox", "workspace", cfg.Workspace, "network", cfg.Network)
+	}
 	watchSvc, err := watcher.NewService(workspaceDir)
 	if err != nil {
-		log.Fatalf("WORKER: Failed to create watcher service: %v", err)
+		slog.Error("Failed to create watcher service", "error", err)
+		exit(1)
 	}
 
-	wsHandler := ws.NewHandler(hub, fsSvc, termSvc, watchSvc)
+	metrics.NewGaugeFunc("worker_terminals", "Number of live terminal sessions.", func() float64 {
+		return float64(termSvc.TerminalCount())
+	})
+	metrics.NewGaugeFunc("worker_watched_directories", "Number of directories monitored by the file watcher.", func() float64 {
+		return float64(watchSvc.WatchCount())
+	})
+
+	// Quick open's file index is kept current by the watcher's event loop.
+	index := filesystem.NewIndex(fsSvc, watchSvc)
+	watchSvc.AddListener(index.HandleEvent)
+	go index.Build()
+
+	wsHandler := ws.NewHandler(hub, fsSvc, termSvc, watchSvc, index)
 	http.HandleFunc("/", wsHandler.ServeHTTP)
 
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -60,12 +184,55 @@ func main() {
 		fmt.Fprintln(w, "OK WORKER")
 	})
 	
-	defer watchSvc.Close()
-	
-	log.Println("WORKER: Starting ws server on :3002...")
-	log.Println("[BRIDGE] Go to http://localhost:3002/health...")
-	err = http.ListenAndServe(":3002", nil)
-	if err != nil {
-		log.Fatalf("WORKER: ListenAndServe failed: %v", err)
+	minFreeBytes := uint64(100 << 20)
+	if v, err := strconv.ParseUint(os.Getenv("WORKER_MIN_FREE_BYTES"), 10, 64); err == nil {
+		minFreeBytes = v
+	}
+
+	checker := health.NewChecker()
+	checker.Register("hydration", func(ctx context.Context) (map[string]interface{}, error) {
+		state := wsHandler.HydrationState()
+		details := map[string]interface{}{"state": state}
+		// Pending is ready: hydration only starts after the bridge forwards
+		// the first client's init, which needs this pod to receive traffic.
+		if state == ws.HydrationInProgress {
+			return details, fmt.Errorf("hydration in progress")
+		}
+		return details, nil
+	})
+	checker.Register("git", health.GitCheck())
+	checker.Register("disk", health.DiskCheck(workspaceDir, minFreeBytes))
+	checker.Register("watcher", func(ctx context.Context) (map[string]interface{}, error) {
+		return watchSvc.Health()
+	})
+	http.Handle("/healthz", health.LivenessHandler())
+	http.Handle("/readyz", checker.ReadinessHandler())
+
+	http.Handle("/metrics", metrics.Handler())
+
+	server := &http.Server{Addr: ":3002"}
+	// On SIGTERM or SIGINT stop accepting connections and let requests in
+	// flight finish, for up to shutdownTimeout. A second signal kills at once.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		stop()
+		slog.Info("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Server shutdown did not finish", "error", err)
+		}
+	}()
+
+	slog.Info("Starting ws server", "addr", ":3002", "health", "http://localhost:3002/health")
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("ListenAndServe failed", "error", err)
+		exit(1)
 	}
+	<-stopped
+	watchSvc.Close()
+	exit(0)
 }
@@ -13,5 +13,5 @@ require github.com/BurntSushi/toml v1.5.0
 
 require (
 	github.com/joho/godotenv v1.5.1
-	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/sys v0.13.0
 )
@@ -0,0 +1,280 @@
+// Package cgroup confines workspace processes to cgroup v2 groups with CPU,
+// memory and pids limits, so learner commands cannot starve the worker.
+//
+// Setup splits the worker's own cgroup into two leaves:
+//
+//	<worker cgroup>/
+//	├── worker/       the worker server itself
+//	└── terminals/    aggregate limits for all learner processes
+//	    └── <group>/  one per terminal, with the same limits
+//
+// The split is required because cgroup v2 only lets a cgroup without
+// processes of its own hand controllers down to children.
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"worker/internal/logging"
+)
+
+func logger() *slog.Logger {
+	return slog.With(logging.KeyComponent, "cgroup")
+}
+
+// controllers the worker enables for workspace processes, when available.
+var controllers = []string{"cpu", "memory", "pids"}
+
+// Limits for workspace processes. Zero values leave a limit unset.
+type Limits struct {
+	MemoryMax int64   // Bytes
+	CPUs      float64 // CPU bandwidth in cores, e.g. 1.5
+	PidsMax   int64
+}
+
+// Hierarchy is the parent cgroup of all terminal groups.
+type Hierarchy struct {
+	path   string
+	limits Limits
+}
+
+// Setup moves the worker into its own leaf and creates the terminals cgroup
+// beside it. It fails if the host does not use cgroup v2 or has not
+// delegated the worker's cgroup to it.
+func Setup(limits Limits) (*Hierarchy, error) {
+	mount, err := mountPoint()
+	if err != nil {
+		return nil, err
+	}
+	own, err := ownPath()
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Join(mount, own)
+
+	workerLeaf := filepath.Join(base, "worker")
+	if err := os.MkdirAll(workerLeaf, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create worker cgroup: %w", err)
+	}
+	if err := writeFile(filepath.Join(workerLeaf, "cgroup.procs"), strconv.Itoa(os.Getpid())); err != nil {
+		return nil, fmt.Errorf("failed to move worker into its cgroup: %w", err)
+	}
+	enabled := enableControllers(base)
+
+	h := &Hierarchy{path: filepath.Join(base, "terminals"), limits: limits}
+	if err := os.MkdirAll(h.path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create terminals cgroup: %w", err)
+	}
+	if err := applyLimits(h.path, limits); err != nil {
+		return nil, err
+	}
+	enableControllers(h.path)
+
+	logger().Info("Workspace processes confined to cgroup", "path", h.path, "controllers", enabled,
+		"memoryMax", limits.MemoryMax, "cpus", limits.CPUs, "pidsMax", limits.PidsMax)
+	return h, nil
+}
+
+// NewGroup creates a child cgroup with the hierarchy's limits.
+func (h *Hierarchy) NewGroup(name string) (*Group, error) {
+	path := filepath.Join(h.path, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup %s: %w", name, err)
+	}
+	if err := applyLimits(path, h.limits); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return &Group{path: path, limits: h.limits}, nil
+}
+
+// Group is the cgroup of a single terminal.
+type Group struct {
+	path   string
+	limits Limits
+}
+
+// Limits returns the limits applied to the group.
+func (g *Group) Limits() Limits {
+	return g.limits
+}
+
+// Open returns a directory descriptor for SysProcAttr.CgroupFD, which starts
+// a child directly inside the group. The caller closes it after starting.
+func (g *Group) Open() (*os.File, error) {
+	return os.OpenFile(g.path, os.O_RDONLY|syscall.O_DIRECTORY, 0)
+}
+
+// Kill kills every process in the group, including ones that left the
+// terminal's session.
+func (g *Group) Kill() error {
+	err := writeFile(filepath.Join(g.path, "cgroup.kill"), "1")
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// cgroup.kill needs Linux 5.14; fall back to signalling each member.
+	procs, err := os.ReadFile(filepath.Join(g.path, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, field := range strings.Fields(string(procs)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+	return nil
+}
+
+// Remove deletes the group once its processes are gone.
+func (g *Group) Remove() error {
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = os.Remove(g.path); err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		// Killed processes take a moment to leave the group.
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("failed to remove cgroup %s: %w", g.path, err)
+}
+
+// Events are the cumulative limit counters of a group.
+type Events struct {
+	OOMKills int64 // Processes killed by the OOM killer
+	PidsMax  int64 // Forks refused because pids.max was reached
+}
+
+// Events reads the group's counters. Counters of disabled controllers are zero.
+func (g *Group) Events() Events {
+	var ev Events
+	ev.OOMKills = readKey(filepath.Join(g.path, "memory.events"), "oom_kill")
+	ev.PidsMax = readKey(filepath.Join(g.path, "pids.events"), "max")
+	return ev
+}
+
+// applyLimits sets the configured limits. Limits whose controller is not
+// enabled (and whose file is therefore missing) are skipped; Setup has
+// already warned about them.
+func applyLimits(path string, limits Limits) error {
+	if limits.MemoryMax > 0 && exists(filepath.Join(path, "memory.max")) {
+		if err := writeFile(filepath.Join(path, "memory.max"), strconv.FormatInt(limits.MemoryMax, 10)); err != nil {
+			return fmt.Errorf("failed to set memory limit: %w", err)
+		}
+		// Without this the limit only pushes processes into swap.
+		if exists(filepath.Join(path, "memory.swap.max")) {
+			writeFile(filepath.Join(path, "memory.swap.max"), "0")
+		}
+	}
+	if limits.CPUs > 0 && exists(filepath.Join(path, "cpu.max")) {
+		const period = 100000
+		quota := int64(limits.CPUs * period)
+		if err := writeFile(filepath.Join(path, "cpu.max"), fmt.Sprintf("%d %d", quota, period)); err != nil {
+			return fmt.Errorf("failed to set CPU limit: %w", err)
+		}
+	}
+	if limits.PidsMax > 0 && exists(filepath.Join(path, "pids.max")) {
+		if err := writeFile(filepath.Join(path, "pids.max"), strconv.FormatInt(limits.PidsMax, 10)); err != nil {
+			return fmt.Errorf("failed to set pids limit: %w", err)
+		}
+	}
+	return nil
+}
+
+// enableControllers delegates the available controllers to path's children
+// and returns the ones it enabled.
+func enableControllers(path string) []string {
+	available, _ := os.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	var enabled []string
+	for _, c := range controllers {
+		if !containsField(string(available), c) {
+			logger().Warn("cgroup controller not available, its limit will not apply", "controller", c, "path", path)
+			continue
+		}
+		if err := writeFile(filepath.Join(path, "cgroup.subtree_control"), "+"+c); err != nil {
+			logger().Warn("Failed to enable cgroup controller", "controller", c, "path", path, "error", err)
+			continue
+		}
+		enabled = append(enabled, c)
+	}
+	return enabled
+}
+
+// mountPoint finds where the cgroup v2 hierarchy is mounted.
+func mountPoint() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Fields after the " - " separator are: fstype, source, options.
+		line := scanner.Text()
+		sep := strings.Index(line, " - ")
+		if sep < 0 {
+			continue
+		}
+		if post := strings.Fields(line[sep+3:]); len(post) > 0 && post[0] == "cgroup2" {
+			if pre := strings.Fields(line[:sep]); len(pre) >= 5 {
+				return pre[4], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("cgroup v2 is not mounted")
+}
+
+// ownPath returns the worker's cgroup v2 path relative to the mount point.
+func ownPath() (string, error) {
+	raw, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("worker is not in a cgroup v2 hierarchy")
+}
+
+func readKey(path, key string) int64 {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+func containsField(s, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func writeFile(path, value string) error {
+	return os.WriteFile(path, []byte(value), 0644)
+}
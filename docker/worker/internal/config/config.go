@@ -10,8 +10,9 @@ import (
 
 // Config represents the workspace configuration
 type Config struct {
-	Run     RunConfig     `toml:"run"`
-	Preview PreviewConfig `toml:"preview"`
+	Run      RunConfig      `toml:"run"`
+	Preview  PreviewConfig  `toml:"preview"`
+	Terminal TerminalConfig `toml:"terminal"`
 }
 
 // RunConfig represents the run command configuration
@@ -25,6 +26,13 @@ type PreviewConfig struct {
 	URL     string `toml:"url"`
 }
 
+// TerminalConfig holds the template's defaults for new terminals
+type TerminalConfig struct {
+	Shell string            `toml:"shell"`
+	Cwd   string            `toml:"cwd"` // Relative to /workspace
+	Env   map[string]string `toml:"env"`
+}
+
 // DefaultConfig returns a default configuration
 func DefaultConfig() *Config {
 	return &Config{
@@ -35,6 +43,9 @@ func DefaultConfig() *Config {
 			Command: "npm run dev",
 			URL:     "http://localhost:3000",
 		},
+		Terminal: TerminalConfig{
+			Shell: "bash",
+		},
 	}
 }
 
@@ -61,6 +72,21 @@ func LoadConfig(baseDir string) (*Config, error) {
 	return &cfg, nil
 }
 
+// ReadConfig is like LoadConfig but never writes: a missing config.toml
+// yields the defaults.
+func ReadConfig(baseDir string) (*Config, error) {
+	configPath := filepath.Join(baseDir, "config.toml")
+
+	cfg := DefaultConfig()
+	if _, err := toml.DecodeFile(configPath, cfg); err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to decode config file: %w", err)
+	}
+	return cfg, nil
+}
+
 // SaveConfig saves the configuration to a TOML file
 func SaveConfig(baseDir string, cfg *Config) error {
 	configPath := filepath.Join(baseDir, "config.toml")
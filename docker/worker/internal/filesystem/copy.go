@@ -0,0 +1,177 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"worker/internal/tracing"
+)
+
+// maxCopyNames bounds the search for a free "copy N" name.
+const maxCopyNames = 1000
+
+// CopyResource copies a file or folder, with its modes, to newRelativePath,
+// or beside itself when that is empty. If the destination is taken the copy
+// gets the next free name, as "name copy.ext", "name copy 2.ext" and so on.
+// It returns the path the copy was made at and the commit hash.
+func (s *Service) CopyResource(ctx context.Context, relativePath, newRelativePath string) (_, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.CopyResource", "path", relativePath, "newPath", newRelativePath)
+	defer span.EndErr(&err)
+
+	srcPath, err := s.securePath(relativePath)
+	if err != nil {
+		return "", "", err
+	}
+	if newRelativePath == "" {
+		newRelativePath = relativePath
+	}
+	dstPath, err := s.securePath(newRelativePath)
+	if err != nil {
+		return "", "", err
+	}
+	if srcPath == s.baseDir || dstPath == s.baseDir {
+		return "", "", errors.New("cannot copy the workspace root")
+	}
+	info, err := os.Lstat(srcPath)
+	if err != nil {
+		return "", "", err
+	}
+	if info.IsDir() && strings.HasPrefix(dstPath, srcPath+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("cannot copy %s into itself", relativePath)
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm); err != nil {
+		return "", "", err
+	}
+	if dstPath, err = freeCopyName(dstPath); err != nil {
+		return "", "", err
+	}
+
+	// Copy to a staged name first so the copy appears whole or not at all.
+	parent, name := filepath.Split(dstPath)
+	tmp, err := os.MkdirTemp(parent, stagingPattern(name))
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(tmp)
+	staged := filepath.Join(tmp, name)
+	if err := copyTree(srcPath, staged); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(staged, dstPath); err != nil {
+		return "", "", err
+	}
+
+	rel, _ := filepath.Rel(s.baseDir, dstPath)
+	newPath := filepath.ToSlash(filepath.Join("/workspace", rel))
+	hash, err := s.commitChanges(ctx, CopyCommitMessage(relativePath, newPath))
+	return newPath, hash, err
+}
+
+// CopyCommitMessage is the commit message of a copy.
+func CopyCommitMessage(from, to string) string {
+	return fmt.Sprintf("FS_COPY_RESOURCE: %s -> %s", from, to)
+}
+
+// freeCopyName returns path if nothing is there, or else the first free
+// "copy" name beside it.
+func freeCopyName(path string) (string, error) {
+	if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+		return path, nil
+	}
+	dir, name := filepath.Split(path)
+	ext := filepath.Ext(name)
+	if ext == name {
+		ext = "" // A dotfile such as ".env" is all name.
+	}
+	stem := strings.TrimSuffix(name, ext)
+	// Copying "a copy.txt" again gives "a copy 2.txt", not "a copy copy.txt".
+	if i := strings.LastIndex(stem, " copy"); i >= 0 && isCopySuffix(stem[i+len(" copy"):]) {
+		stem = stem[:i]
+	}
+	for n := 1; n <= maxCopyNames; n++ {
+		candidate := stem + " copy" + ext
+		if n > 1 {
+			candidate = fmt.Sprintf("%s copy %d%s", stem, n, ext)
+		}
+		candidate = filepath.Join(dir, candidate)
+		if _, err := os.Lstat(candidate); errors.Is(err, fs.ErrNotExist) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free name for a copy of %s", name)
+}
+
+// isCopySuffix reports whether s, what follows " copy" in a name, is empty or
+// a copy number.
+func isCopySuffix(s string) bool {
+	if s == "" {
+		return true
+	}
+	digits := strings.TrimPrefix(s, " ")
+	return digits != s && digits != "" && strings.Trim(digits, "0123456789") == ""
+}
+
+// copyTree copies src to dst, which must not exist. Folders and files keep
+// their permissions, and symlinks are copied as links rather than followed.
+func copyTree(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case info.IsDir():
+		if err := os.Mkdir(dst, 0700); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), stagingPrefix) {
+				continue // Half of another change in progress
+			}
+			if err := copyTree(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+				return err
+			}
+		}
+		// Set last, so a read-only folder can still be filled.
+		return os.Chmod(dst, info.Mode().Perm())
+	case info.Mode().IsRegular():
+		return copyFile(src, dst, info.Mode().Perm())
+	default:
+		return nil // Sockets, devices and pipes are not copied.
+	}
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// The umask may have narrowed the mode at creation.
+		err = os.Chmod(dst, mode)
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsCopySuffix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"", true},
+		{" 2", true},
+		{" 10", true},
+		{" 007", true},
+		{"2", false},
+		{" ", false},
+		{"  2", false},
+		{" x", false},
+		{" 2a", false},
+		{" -2", false},
+		{"x", false},
+	}
+	for _, tt := range tests {
+		if got := isCopySuffix(tt.in); got != tt.want {
+			t.Errorf("isCopySuffix(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFreeCopyName(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		path     string
+		want     string
+	}{
+		{"free path", nil, "a.txt", "a.txt"},
+		{"first copy", []string{"a.txt"}, "a.txt", "a copy.txt"},
+		{"second copy", []string{"a.txt", "a copy.txt"}, "a.txt", "a copy 2.txt"},
+		{"fills a gap", []string{"a.txt", "a copy 2.txt"}, "a.txt", "a copy.txt"},
+		{"copy of a copy", []string{"a.txt", "a copy.txt"}, "a copy.txt", "a copy 2.txt"},
+		{"copy of a numbered copy", []string{"a copy.txt", "a copy 2.txt"}, "a copy 2.txt", "a copy 3.txt"},
+		{"no extension", []string{"Makefile"}, "Makefile", "Makefile copy"},
+		{"dotfile", []string{".env"}, ".env", ".env copy"},
+		{"dotfile copy", []string{".env", ".env copy"}, ".env", ".env copy 2"},
+		{"last extension only", []string{"a.tar.gz"}, "a.tar.gz", "a.tar copy.gz"},
+		{"copy inside a word", []string{"photocopy.txt"}, "photocopy.txt", "photocopy copy.txt"},
+		{"not a copy number", []string{"a copy 2b.txt"}, "a copy 2b.txt", "a copy 2b copy.txt"},
+		{"copy word with letters", []string{"a copyx"}, "a copyx", "a copyx copy"},
+		{"nested", []string{"sub/a.txt"}, "sub/a.txt", "sub/a copy.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.existing {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := freeCopyName(filepath.Join(dir, tt.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(dir, tt.want); got != want {
+				t.Errorf("freeCopyName(%q) = %q, want %q", tt.path, strings.TrimPrefix(got, dir+"/"), tt.want)
+			}
+		})
+	}
+}
+
+func TestFreeCopyNameTakenByLink(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A dangling link still takes its name.
+	if err := os.Symlink("missing", filepath.Join(dir, "a copy.txt")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := freeCopyName(filepath.Join(dir, "a.txt"))
+	if err != nil || got != filepath.Join(dir, "a copy 2.txt") {
+		t.Errorf("freeCopyName = %q, %v, want %q", got, err, "a copy 2.txt")
+	}
+}
+
+func TestFreeCopyNameExhausted(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"a.txt", "a copy.txt"}
+	for n := 2; n <= maxCopyNames; n++ {
+		names = append(names, fmt.Sprintf("a copy %d.txt", n))
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := freeCopyName(filepath.Join(dir, "a.txt")); err == nil || !strings.Contains(err.Error(), "no free name") {
+		t.Errorf("freeCopyName error = %v, want no free name", err)
+	}
+}
@@ -0,0 +1,216 @@
+package filesystem
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// globPattern is one compiled gitignore-style pattern.
+type globPattern struct {
+	segments []string // Split on "/", "**" matches any number of segments
+	negate   bool     // "!pattern" re-includes what an earlier pattern excluded
+	dirOnly  bool     // "pattern/" matches directories only
+	anchored bool     // Contains a slash, so it matches from base rather than any basename
+	base     string   // Directory of the .gitignore, relative to the workspace ("" for the root)
+}
+
+// parseGlob compiles a pattern in gitignore syntax. It returns false for
+// blank lines and comments.
+func parseGlob(line, base string) (globPattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return globPattern{}, false
+	}
+	p := globPattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:] // Escaped leading "!" or "#"
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return globPattern{}, false
+	}
+	p.segments = strings.Split(line, "/")
+	return p, true
+}
+
+// match reports whether rel, a slash-separated path relative to the
+// workspace, matches the pattern.
+func (p globPattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.base != "" {
+		var ok bool
+		if rel, ok = strings.CutPrefix(rel, p.base+"/"); !ok {
+			return false
+		}
+	}
+	if !p.anchored {
+		ok, _ := path.Match(p.segments[0], path.Base(rel))
+		return ok
+	}
+	return matchSegments(p.segments, strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(rest, segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// ignoreRules are the .gitignore patterns in effect for a directory, from the
+// workspace root down. Later patterns take precedence.
+type ignoreRules []globPattern
+
+// ignored reports whether rel is excluded by the rules.
+func (r ignoreRules) ignored(rel string, isDir bool) bool {
+	for i := len(r) - 1; i >= 0; i-- {
+		if r[i].match(rel, isDir) {
+			return !r[i].negate
+		}
+	}
+	return false
+}
+
+// enter returns the rules for the directory at dir (absolute) and rel (relative
+// to the workspace), adding its .gitignore if it has one.
+func (r ignoreRules) enter(dir, rel string) ignoreRules {
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return r
+	}
+	defer f.Close()
+	// Clip so siblings appending their own rules never share a backing array.
+	out := slices.Clip(r)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p, ok := parseGlob(scanner.Text(), rel); ok {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// globSet matches paths against include or exclude globs from a request,
+// such as "*.go" or "src/**/test_*.py".
+type globSet []globPattern
+
+func compileGlobs(globs []string) globSet {
+	var set globSet
+	for _, g := range globs {
+		if p, ok := parseGlob(g, ""); ok {
+			set = append(set, p)
+		}
+	}
+	return set
+}
+
+func (s globSet) match(rel string, isDir bool) bool {
+	for _, p := range s {
+		if p.match(rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkFunc receives each file and folder of a workspace walk, by absolute and
+// workspace-relative path. Returning filepath.SkipDir for a folder skips its
+// contents; any other error stops the walk.
+type walkFunc func(full, rel string, entry os.DirEntry) error
+
+// walkWorkspace visits everything below dir that .gitignore does not exclude,
+// skipping .git and staged names, which it removes if stale. rel is dir relative to the workspace root, "" for the root.
+func (s *Service) walkWorkspace(dir, rel string, rules ignoreRules, fn walkFunc) error {
+	rules = rules.enter(dir, rel)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == ".git" {
+			continue
+		}
+		if strings.HasPrefix(name, stagingPrefix) {
+			removeIfStale(filepath.Join(dir, name), entry)
+			continue
+		}
+		childRel := name
+		if rel != "" {
+			childRel = rel + "/" + name
+		}
+		full := filepath.Join(dir, name)
+		if rules.ignored(childRel, entry.IsDir()) {
+			continue
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			continue // Symlinks may point outside the workspace.
+		}
+		err := fn(full, childRel, entry)
+		if entry.IsDir() {
+			if err == filepath.SkipDir {
+				continue
+			}
+			if err == nil {
+				err = s.walkWorkspace(full, childRel, rules, fn)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ignoreRulesFor returns the .gitignore rules in effect for the directory rel,
+// by reading every .gitignore from the workspace root down to its parent.
+func (s *Service) ignoreRulesFor(rel string) ignoreRules {
+	var rules ignoreRules
+	if rel == "" {
+		return rules
+	}
+	dir, dirRel := s.baseDir, ""
+	rules = rules.enter(dir, dirRel)
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		if dirRel == "" {
+			dirRel = part
+		} else {
+			dirRel += "/" + part
+		}
+		rules = rules.enter(dir, dirRel)
+	}
+	return rules
+}
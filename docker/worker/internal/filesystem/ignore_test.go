@@ -0,0 +1,138 @@
+package filesystem
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseGlob(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+		want globPattern
+	}{
+		{line: "", ok: false},
+		{line: "   ", ok: false},
+		{line: "# comment", ok: false},
+		{line: "/", ok: false},
+		{line: "*.log", ok: true, want: globPattern{segments: []string{"*.log"}}},
+		{line: "*.log \t\r", ok: true, want: globPattern{segments: []string{"*.log"}}},
+		{line: "!keep.log", ok: true, want: globPattern{segments: []string{"keep.log"}, negate: true}},
+		{line: `\#literal`, ok: true, want: globPattern{segments: []string{"#literal"}}},
+		{line: `\!literal`, ok: true, want: globPattern{segments: []string{"!literal"}}},
+		// A trailing slash alone does not anchor the pattern.
+		{line: "build/", ok: true, want: globPattern{segments: []string{"build"}, dirOnly: true}},
+		{line: "/build", ok: true, want: globPattern{segments: []string{"build"}, anchored: true}},
+		{line: "/build/", ok: true, want: globPattern{segments: []string{"build"}, anchored: true, dirOnly: true}},
+		{line: "docs/*.md", ok: true, want: globPattern{segments: []string{"docs", "*.md"}, anchored: true}},
+		{line: "**/temp", ok: true, want: globPattern{segments: []string{"**", "temp"}, anchored: true}},
+		{line: "!/src/**/gen/", ok: true, want: globPattern{segments: []string{"src", "**", "gen"}, negate: true, anchored: true, dirOnly: true}},
+	}
+	for _, tt := range tests {
+		got, ok := parseGlob(tt.line, "")
+		if ok != tt.ok {
+			t.Errorf("parseGlob(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if !slices.Equal(got.segments, tt.want.segments) || got.negate != tt.want.negate ||
+			got.dirOnly != tt.want.dirOnly || got.anchored != tt.want.anchored {
+			t.Errorf("parseGlob(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/b/c", false},
+		{"a/b", "x/a/b", false},
+		{"a/*", "a/b", true},
+		{"a/*", "a/b/c", false},
+		// "**" matches any number of segments, none included.
+		{"**/b", "b", true},
+		{"**/b", "a/b", true},
+		{"**/b", "a/x/b", true},
+		{"**/b", "a/b/c", false},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "a/x/y/c", false},
+		{"a/**", "a/x", true},
+		{"a/**", "a/x/y", true},
+		{"a/**", "b/x", false},
+		{"**", "anything/at/all", true},
+		{"**/*.go", "cmd/main.go", true},
+		{"**/*.go", "cmd/main.gox", false},
+	}
+	for _, tt := range tests {
+		p, _ := parseGlob(tt.pattern, "")
+		if got := matchSegments(p.segments, strings.Split(tt.path, "/")); got != tt.want {
+			t.Errorf("matchSegments(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestGlobPatternMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		base    string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"unanchored matches any basename", "*.log", "", "a/b/debug.log", false, true},
+		{"unanchored does not match a folder above", "*.log", "", "x.log/file", false, false},
+		{"anchored matches from the base only", "/build", "", "build", true, true},
+		{"anchored skips deeper names", "/build", "", "src/build", true, false},
+		{"dirOnly ignores files", "build/", "", "build", false, false},
+		{"dirOnly matches folders anywhere", "build/", "", "src/build", true, true},
+		{"nested base applies inside it", "*.tmp", "sub", "sub/a/x.tmp", false, true},
+		{"nested base ignores siblings", "*.tmp", "sub", "other/x.tmp", false, false},
+		{"nested base is not a name prefix", "*.tmp", "sub", "subway/x.tmp", false, false},
+		{"anchored nested pattern", "/gen", "sub", "sub/gen", true, true},
+		{"anchored nested pattern deeper", "/gen", "sub", "sub/a/gen", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := parseGlob(tt.pattern, tt.base)
+			if !ok {
+				t.Fatalf("parseGlob(%q) failed", tt.pattern)
+			}
+			if got := p.match(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("match(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIgnoreRulesNegate(t *testing.T) {
+	var rules ignoreRules
+	for _, line := range []string{"*.log", "!keep.log", "logs/", "!logs/"} {
+		p, _ := parseGlob(line, "")
+		rules = append(rules, p)
+	}
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"debug.log", false, true},
+		{"keep.log", false, false}, // Re-included by the later pattern
+		{"src/keep.log", false, false},
+		{"logs", true, false}, // The last matching pattern wins
+		{"main.go", false, false},
+	}
+	for _, tt := range tests {
+		if got := rules.ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package filesystem
+
+import (
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"worker/internal/watcher"
+	"worker/pkg/types"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Index limits. Every indexed folder takes an inotify watch.
+const (
+	MaxIndexedFiles    = 200000
+	MaxIndexedDirs     = 20000
+	DefaultFindResults = 50
+	MaxFindResults     = 500
+
+	// A changed .gitignore rebuilds the index after this quiet period.
+	indexRebuildDelay = 500 * time.Millisecond
+	// Only this much of a path is scored; the rest cannot match.
+	maxScoredPathLength = 512
+)
+
+// Index is an in-memory list of the workspace's files for quick open. It
+// covers what a search covers, everything but .git and ignored paths, and is
+// kept current from watcher events.
+type Index struct {
+	fs    *Service
+	watch *watcher.Service
+
+	// buildMu makes builds take turns. Two at once would each take the
+	// other's pending events and unwatch folders the other still indexes.
+	buildMu sync.Mutex
+
+	mu       sync.RWMutex
+	tree     *indexTree
+	building bool
+	pending  []fsnotify.Event // Events that arrived during a rebuild
+	rebuild  *time.Timer
+}
+
+// indexTree is the indexed content of the workspace, by relative path.
+type indexTree struct {
+	files map[string]string          // File -> path with ASCII lowered, for matching
+	dirs  map[string]map[string]bool // Folder -> its children, true for folders
+	// truncated is set once a limit is reached or a folder cannot be watched.
+	truncated bool
+}
+
+func newIndexTree() *indexTree {
+	return &indexTree{files: make(map[string]string), dirs: map[string]map[string]bool{"": {}}}
+}
+
+// NewIndex returns an empty index of fs's workspace. Build fills it, and
+// HandleEvent, registered as a watcher listener, keeps it current.
+func NewIndex(fs *Service, watch *watcher.Service) *Index {
+	return &Index{fs: fs, watch: watch, tree: newIndexTree()}
+}
+
+// Build indexes the whole workspace, replacing the current index. A build
+// started while another runs waits for it, then starts over.
+func (x *Index) Build() error {
+	x.buildMu.Lock()
+	defer x.buildMu.Unlock()
+	start := time.Now()
+	x.mu.Lock()
+	x.building = true
+	x.mu.Unlock()
+
+	tree := newIndexTree()
+	err := x.fs.walkWorkspace(x.fs.baseDir, "", nil, x.visitor(tree))
+	x.watchDir(tree, "")
+
+	x.mu.Lock()
+	old := x.tree
+	x.tree = tree
+	pending := x.pending
+	x.pending, x.building = nil, false
+	for _, event := range pending {
+		x.applyLocked(event)
+	}
+	files, truncated := len(tree.files), tree.truncated
+	x.mu.Unlock()
+
+	// Folders that are gone or now ignored no longer need watching.
+	for dir := range old.dirs {
+		x.mu.RLock()
+		_, kept := x.tree.dirs[dir]
+		x.mu.RUnlock()
+		if !kept {
+			x.watch.UnwatchIndexed(x.dirPath(dir))
+		}
+	}
+	if err != nil {
+		logger().Warn("File index is incomplete", "error", err)
+	}
+	logger().Info("Indexed workspace files", "files", files, "truncated", truncated, "duration", time.Since(start))
+	return err
+}
+
+// HandleEvent updates the index for a watcher event.
+func (x *Index) HandleEvent(event fsnotify.Event) {
+	if filepath.Base(event.Name) == ".gitignore" {
+		x.scheduleRebuild()
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.building {
+		x.pending = append(x.pending, event)
+		return
+	}
+	x.applyLocked(event)
+}
+
+func (x *Index) applyLocked(event fsnotify.Event) {
+	rel, err := filepath.Rel(x.fs.baseDir, event.Name)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+	if IsStagingPath(rel) {
+		return // Indexed under its final name once renamed into place.
+	}
+	switch {
+	case event.Has(fsnotify.Create):
+		x.addLocked(rel)
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		x.removeLocked(rel)
+	}
+}
+
+// addLocked indexes a new file or folder, and everything in the folder.
+func (x *Index) addLocked(rel string) {
+	parent, name := parentDir(rel), path.Base(rel)
+	if name == ".git" {
+		return
+	}
+	if _, indexed := x.tree.dirs[parent]; !indexed {
+		return // Inside .git, an ignored folder or beyond the limits
+	}
+	full := filepath.Join(x.fs.baseDir, rel)
+	info, err := os.Lstat(full)
+	if err != nil || !info.IsDir() && !info.Mode().IsRegular() {
+		return
+	}
+	rules := x.fs.ignoreRulesFor(rel)
+	if rules.ignored(rel, info.IsDir()) {
+		return
+	}
+	visit := x.visitor(x.tree)
+	if err := visit(full, rel, fs.FileInfoToDirEntry(info)); err != nil || !info.IsDir() {
+		return
+	}
+	if err := x.fs.walkWorkspace(full, rel, rules, visit); err != nil {
+		logger().Warn("Failed to index new folder", "path", rel, "error", err)
+	}
+}
+
+// removeLocked drops a file or folder, and everything in the folder.
+func (x *Index) removeLocked(rel string) {
+	delete(x.tree.dirs[parentDir(rel)], path.Base(rel))
+	delete(x.tree.files, rel)
+	x.removeDirLocked(rel)
+}
+
+func (x *Index) removeDirLocked(rel string) {
+	children, ok := x.tree.dirs[rel]
+	if !ok {
+		return
+	}
+	for name, isDir := range children {
+		child := joinRel(rel, name)
+		if isDir {
+			x.removeDirLocked(child)
+		} else {
+			delete(x.tree.files, child)
+		}
+	}
+	delete(x.tree.dirs, rel)
+	x.watch.UnwatchIndexed(x.dirPath(rel))
+}
+
+// visitor returns the walk callback that adds files and folders to tree.
+func (x *Index) visitor(tree *indexTree) walkFunc {
+	return func(full, rel string, entry os.DirEntry) error {
+		parent, name := parentDir(rel), path.Base(rel)
+		if entry.IsDir() {
+			if len(tree.dirs) >= MaxIndexedDirs || !x.watchDir(tree, rel) {
+				tree.truncated = true
+				return filepath.SkipDir
+			}
+			if _, exists := tree.dirs[rel]; !exists {
+				tree.dirs[rel] = make(map[string]bool)
+			}
+			tree.dirs[parent][name] = true
+			return nil
+		}
+		if len(tree.files) >= MaxIndexedFiles {
+			tree.truncated = true
+			return nil
+		}
+		tree.files[rel] = lowerASCII(rel)
+		tree.dirs[parent][name] = false
+		return nil
+	}
+}
+
+// watchDir watches the folder rel for changes to keep the index current.
+func (x *Index) watchDir(tree *indexTree, rel string) bool {
+	if err := x.watch.WatchIndexed(x.dirPath(rel)); err != nil {
+		if !tree.truncated {
+			logger().Warn("Cannot watch folder, the file index will be incomplete", "path", rel, "error", err)
+		}
+		return false
+	}
+	return true
+}
+
+func (x *Index) scheduleRebuild() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.rebuild != nil {
+		x.rebuild.Stop()
+	}
+	x.rebuild = time.AfterFunc(indexRebuildDelay, func() { x.Build() })
+}
+
+// dirPath is the actual path of the folder rel, as the watcher knows it.
+func (x *Index) dirPath(rel string) string {
+	if rel == "" {
+		return x.fs.baseDir
+	}
+	return filepath.Join(x.fs.baseDir, rel)
+}
+
+// Find returns the indexed files that best match query as a fuzzy pattern,
+// best first. complete is false while the index is being built or when it
+// hit a limit.
+func (x *Index) Find(query string, limit int) (_ []types.FileMatch, complete bool) {
+	if limit <= 0 {
+		limit = DefaultFindResults
+	}
+	limit = min(limit, MaxFindResults)
+	needle := lowerASCII(strings.ReplaceAll(query, " ", ""))
+
+	type candidate struct {
+		rel   string
+		score float64
+	}
+	var candidates []candidate
+	x.mu.RLock()
+	complete = !x.building && !x.tree.truncated
+	for rel, lower := range x.tree.files {
+		if needle == "" {
+			candidates = append(candidates, candidate{rel: rel})
+		} else if isSubsequence(needle, lower) {
+			// Matches only past maxScoredPathLength score -Inf.
+			if score := fuzzyScore(needle, rel, lower, nil); !math.IsInf(score, -1) {
+				candidates = append(candidates, candidate{rel: rel, score: score})
+			}
+		}
+	}
+	x.mu.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if len(a.rel) != len(b.rel) {
+			return len(a.rel) < len(b.rel)
+		}
+		return a.rel < b.rel
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	matches := make([]types.FileMatch, 0, len(candidates))
+	for _, c := range candidates {
+		match := types.FileMatch{Path: "/workspace/" + c.rel, Score: math.Round(c.score*1000) / 1000}
+		if needle != "" {
+			positions := make([]int, len(needle))
+			fuzzyScore(needle, c.rel, lowerASCII(c.rel), positions)
+			// Report positions in UTF-16 units of Path, as the editor counts.
+			offset := len("/workspace/")
+			for _, p := range positions {
+				match.Positions = append(match.Positions, offset+utf16Len([]byte(c.rel[:p])))
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches, complete
+}
+
+// Fuzzy scoring, after fzy: matches score more at the start of a path
+// segment or word and in runs, and gaps between them cost a little.
+const (
+	scoreGapLeading  = -0.005
+	scoreGapTrailing = -0.005
+	scoreGapInner    = -0.01
+	scoreConsecutive = 1.0
+	scoreSlash       = 0.9
+	scoreWord        = 0.8
+	scoreCapital     = 0.7
+	scoreDot         = 0.6
+	// Matching the file name rather than its folders is worth a little more.
+	scoreBasename = 0.2
+)
+
+// isSubsequence reports whether every byte of needle occurs in haystack, in
+// order.
+func isSubsequence(needle, haystack string) bool {
+	i := 0
+	for j := 0; i < len(needle) && j < len(haystack); j++ {
+		if needle[i] == haystack[j] {
+			i++
+		}
+	}
+	return i == len(needle)
+}
+
+// fuzzyScore scores needle, lowered, against rel. If positions is not nil it
+// receives the byte offset in rel of each needle byte in the best match.
+func fuzzyScore(needle, rel, lower string, positions []int) float64 {
+	n, m := len(needle), min(len(rel), maxScoredPathLength)
+	if n > m {
+		return math.Inf(-1)
+	}
+	bonus := make([]float64, m)
+	base := strings.LastIndexByte(rel, '/') + 1
+	prev := byte('/')
+	for j := 0; j < m; j++ {
+		c := rel[j]
+		switch {
+		case prev == '/':
+			bonus[j] = scoreSlash
+		case prev == '-' || prev == '_' || prev == ' ':
+			bonus[j] = scoreWord
+		case prev == '.':
+			bonus[j] = scoreDot
+		case 'a' <= prev && prev <= 'z' && 'A' <= c && c <= 'Z':
+			bonus[j] = scoreCapital
+		}
+		if j >= base {
+			bonus[j] += scoreBasename
+		}
+		prev = c
+	}
+
+	// d[i*m+j] is the best score with needle[i] matched at j; best[i*m+j] the
+	// best with needle[:i+1] matched within rel[:j+1].
+	d := make([]float64, n*m)
+	best := make([]float64, n*m)
+	negInf := math.Inf(-1)
+	for i := 0; i < n; i++ {
+		prevScore := negInf
+		gap := scoreGapInner
+		if i == n-1 {
+			gap = scoreGapTrailing
+		}
+		for j := 0; j < m; j++ {
+			k := i*m + j
+			if needle[i] != lower[j] {
+				d[k] = negInf
+				prevScore += gap
+				best[k] = prevScore
+				continue
+			}
+			score := negInf
+			if i == 0 {
+				score = float64(j)*scoreGapLeading + bonus[j]
+			} else if j > 0 {
+				score = max(best[k-m-1]+bonus[j], d[k-m-1]+scoreConsecutive)
+			}
+			d[k] = score
+			prevScore = max(score, prevScore+gap)
+			best[k] = prevScore
+		}
+	}
+
+	if positions != nil {
+		required := false
+		j := m - 1
+		for i := n - 1; i >= 0; i-- {
+			for ; j >= 0; j-- {
+				k := i*m + j
+				if d[k] != negInf && (required || d[k] == best[k]) {
+					required = i > 0 && j > 0 && best[k] == d[k-m-1]+scoreConsecutive
+					positions[i] = j
+					j--
+					break
+				}
+			}
+		}
+	}
+	return best[n*m-1]
+}
+
+// lowerASCII lowers ASCII letters only, so byte offsets stay the same.
+func lowerASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+func parentDir(rel string) string {
+	if dir := path.Dir(rel); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+func joinRel(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
@@ -0,0 +1,157 @@
+package filesystem
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIsSubsequence(t *testing.T) {
+	tests := []struct {
+		needle, haystack string
+		want             bool
+	}{
+		{"", "", true},
+		{"", "abc", true},
+		{"abc", "abc", true},
+		{"ac", "abc", true},
+		{"ca", "abc", false},
+		{"abcd", "abc", false},
+		{"aa", "a", false},
+		{"aa", "bab", false},
+		{"aa", "abca", true},
+	}
+	for _, tt := range tests {
+		if got := isSubsequence(tt.needle, tt.haystack); got != tt.want {
+			t.Errorf("isSubsequence(%q, %q) = %v, want %v", tt.needle, tt.haystack, got, tt.want)
+		}
+	}
+}
+
+func TestFuzzyScorePositions(t *testing.T) {
+	tests := []struct {
+		needle, rel string
+		want        []int
+	}{
+		// Starts of the file name and extension beat earlier letters.
+		{"mg", "cmd/main.go", []int{4, 9}},
+		// A run in the file name beats the same run in a folder.
+		{"main", "src/domain/main.go", []int{11, 12, 13, 14}},
+		// Word starts after "-", "_" and camel case humps.
+		{"fb", "foo-bar", []int{0, 4}},
+		{"fb", "foo_bar", []int{0, 4}},
+		{"fb", "fooBar", []int{0, 3}},
+		{"ab", "ab", []int{0, 1}},
+		// Lowered matching, positions in the original path.
+		{"rm", "README.md", []int{0, 7}},
+	}
+	for _, tt := range tests {
+		positions := make([]int, len(tt.needle))
+		fuzzyScore(tt.needle, tt.rel, lowerASCII(tt.rel), positions)
+		if !slices.Equal(positions, tt.want) {
+			t.Errorf("fuzzyScore(%q, %q) positions = %v, want %v", tt.needle, tt.rel, positions, tt.want)
+		}
+	}
+}
+
+func TestFuzzyScoreNoMatch(t *testing.T) {
+	if score := fuzzyScore("abcd", "abc", "abc", nil); !math.IsInf(score, -1) {
+		t.Errorf("needle longer than path scored %v, want -Inf", score)
+	}
+	// Only the first maxScoredPathLength bytes are scored.
+	long := strings.Repeat("x", maxScoredPathLength) + "/z"
+	if score := fuzzyScore("z", long, long, nil); !math.IsInf(score, -1) {
+		t.Errorf("match past the scored length scored %v, want -Inf", score)
+	}
+}
+
+// TestFuzzyScoreBestAlignment checks the score and the positions traced back
+// through the score matrices against every possible alignment.
+func TestFuzzyScoreBestAlignment(t *testing.T) {
+	tests := []struct{ needle, rel string }{
+		{"ab", "abab"},
+		{"aa", "aaaa"},
+		{"abc", "a/b_abc"},
+		{"ss", "src/ss.s"},
+		{"tt", "test/tt-t"},
+		{"ab", "xa/b_ab"},
+		{"aba", "ab/aba/ba"},
+		{"fb", "fooBar/fb"},
+		{"ii", "iii/i.i"},
+	}
+	for _, tt := range tests {
+		lower := lowerASCII(tt.rel)
+		want := math.Inf(-1)
+		eachAlignment(tt.needle, lower, nil, func(positions []int) {
+			want = max(want, alignmentScore(tt.rel, positions))
+		})
+		positions := make([]int, len(tt.needle))
+		got := fuzzyScore(tt.needle, tt.rel, lower, positions)
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("fuzzyScore(%q, %q) = %v, best alignment scores %v", tt.needle, tt.rel, got, want)
+			continue
+		}
+		for i, p := range positions {
+			if lower[p] != tt.needle[i] || i > 0 && p <= positions[i-1] {
+				t.Fatalf("fuzzyScore(%q, %q) positions %v are not an alignment", tt.needle, tt.rel, positions)
+			}
+		}
+		if score := alignmentScore(tt.rel, positions); math.Abs(score-want) > 1e-9 {
+			t.Errorf("fuzzyScore(%q, %q) positions %v score %v, best alignment scores %v", tt.needle, tt.rel, positions, score, want)
+		}
+	}
+}
+
+// eachAlignment calls fn with each way of matching needle to increasing
+// positions of lower.
+func eachAlignment(needle, lower string, positions []int, fn func([]int)) {
+	if len(positions) == len(needle) {
+		fn(positions)
+		return
+	}
+	from := 0
+	if len(positions) > 0 {
+		from = positions[len(positions)-1] + 1
+	}
+	for j := from; j < len(lower); j++ {
+		if lower[j] == needle[len(positions)] {
+			eachAlignment(needle, lower, append(positions, j), fn)
+		}
+	}
+}
+
+// alignmentScore scores one alignment the way fuzzyScore's recurrence does.
+func alignmentScore(rel string, positions []int) float64 {
+	bonus := make([]float64, len(rel))
+	base := strings.LastIndexByte(rel, '/') + 1
+	prev := byte('/')
+	for j := range len(rel) {
+		c := rel[j]
+		switch {
+		case prev == '/':
+			bonus[j] = scoreSlash
+		case prev == '-' || prev == '_' || prev == ' ':
+			bonus[j] = scoreWord
+		case prev == '.':
+			bonus[j] = scoreDot
+		case 'a' <= prev && prev <= 'z' && 'A' <= c && c <= 'Z':
+			bonus[j] = scoreCapital
+		}
+		if j >= base {
+			bonus[j] += scoreBasename
+		}
+		prev = c
+	}
+
+	score := float64(positions[0])*scoreGapLeading + bonus[positions[0]]
+	for i := 1; i < len(positions); i++ {
+		p, last := positions[i], positions[i-1]
+		if p == last+1 {
+			score += max(bonus[p], scoreConsecutive)
+		} else {
+			score += float64(p-last-1)*scoreGapInner + bonus[p]
+		}
+	}
+	return score + float64(len(rel)-1-positions[len(positions)-1])*scoreGapTrailing
+}
@@ -0,0 +1,219 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+	"worker/internal/tracing"
+	"worker/pkg/types"
+)
+
+// MaxPatchEdits bounds the number of edits in one patch.
+const MaxPatchEdits = 10000
+
+// PatchFile applies range edits, as the editor reports them, to a text file
+// that must still be at expectedVersion. Like the changes of one editor event,
+// every range refers to the file at expectedVersion and no two may overlap.
+// It returns the new version and the commit hash.
+func (s *Service) PatchFile(ctx context.Context, relativePath, expectedVersion string, edits []types.TextEdit) (_, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.PatchFile", "path", relativePath, "edits", len(edits))
+	defer span.EndErr(&err)
+
+	if expectedVersion == "" {
+		return "", "", errors.New("expectedVersion is required to patch a file")
+	}
+	if len(edits) > MaxPatchEdits {
+		return "", "", fmt.Errorf("a patch may have at most %d edits", MaxPatchEdits)
+	}
+	fullPath, err := s.securePath(relativePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	s.writeMu.Lock()
+	text, err := s.patchLocked(relativePath, fullPath, expectedVersion, edits)
+	s.writeMu.Unlock()
+	if err != nil {
+		return "", "", err
+	}
+
+	hash, err := s.commitChanges(ctx, fmt.Sprintf("FS_PATCH_FILE: %s", relativePath))
+	return ContentVersion([]byte(text)), hash, err
+}
+
+// patchLocked checks the version of the file, applies edits to it and
+// returns its new text. The caller holds writeMu.
+func (s *Service) patchLocked(relativePath, fullPath, expectedVersion string, edits []types.TextEdit) (string, error) {
+	if err := s.checkVersion(relativePath, fullPath, expectedVersion); err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return "", err
+	}
+	if isBinary(content) {
+		return "", fmt.Errorf("%s is not a text file", relativePath)
+	}
+	resolved, size, err := resolveEdits(string(content), edits)
+	if err != nil {
+		return "", err
+	}
+	if err := s.checkInlineSize(relativePath, int64(size)); err != nil {
+		return "", err
+	}
+	text := applyEdits(string(content), resolved, size)
+	if err := os.WriteFile(fullPath, []byte(text), 0644); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// resolvedEdit is a TextEdit with its range as byte offsets.
+type resolvedEdit struct {
+	start, end int
+	text       string
+}
+
+// resolveEdits converts the ranges of edits, which all refer to text, to byte
+// offsets in a single pass over text. It returns them in order of position,
+// edits at the same position in the order given, and the size of the result.
+func resolveEdits(text string, edits []types.TextEdit) ([]resolvedEdit, int, error) {
+	type position struct {
+		line, column int
+		edit         int
+		end          bool
+	}
+	positions := make([]position, 0, 2*len(edits))
+	for i, edit := range edits {
+		r := edit.Range
+		positions = append(positions,
+			position{line: r.StartLineNumber, column: r.StartColumn, edit: i},
+			position{line: r.EndLineNumber, column: r.EndColumn, edit: i, end: true})
+	}
+	sort.SliceStable(positions, func(i, j int) bool {
+		a, b := positions[i], positions[j]
+		if a.line != b.line {
+			return a.line < b.line
+		}
+		return a.column < b.column
+	})
+
+	resolved := make([]resolvedEdit, len(edits))
+	cursor := textCursor{text: text}
+	for _, p := range positions {
+		offset, err := cursor.seek(p.line, p.column)
+		if err != nil {
+			return nil, 0, fmt.Errorf("edit %d: %w", p.edit+1, err)
+		}
+		if p.end {
+			resolved[p.edit].end = offset
+		} else {
+			resolved[p.edit].start = offset
+		}
+	}
+
+	size := len(text)
+	for i, edit := range edits {
+		r := &resolved[i]
+		if r.end < r.start {
+			return nil, 0, fmt.Errorf("edit %d: range ends before it starts", i+1)
+		}
+		r.text = edit.Text
+		size += len(r.text) - (r.end - r.start)
+	}
+	order := make([]int, len(edits))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return resolved[order[i]].start < resolved[order[j]].start })
+	sorted := make([]resolvedEdit, len(edits))
+	for i, k := range order {
+		sorted[i] = resolved[k]
+		if i > 0 && sorted[i].start < sorted[i-1].end {
+			return nil, 0, fmt.Errorf("edits %d and %d overlap", order[i-1]+1, k+1)
+		}
+	}
+	return sorted, size, nil
+}
+
+// applyEdits builds the result of edits, resolved against text, which comes
+// to size bytes.
+func applyEdits(text string, edits []resolvedEdit, size int) string {
+	var b strings.Builder
+	b.Grow(size)
+	last := 0
+	for _, edit := range edits {
+		b.WriteString(text[last:edit.start])
+		b.WriteString(edit.text)
+		last = edit.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// textCursor converts editor positions in text to byte offsets. Positions
+// must be sought in order, so that text is scanned once however many there
+// are.
+type textCursor struct {
+	text      string
+	line      int // 1-based, 0 before the first seek
+	lineStart int
+	lineEnd   int // Before the "\n" or "\r\n" ending the line
+	pos       int // Byte offset reached in the line
+	units     int // UTF-16 units from lineStart to pos
+}
+
+// seek returns the byte offset of a 1-based line and UTF-16 column, as the
+// editor counts them. A "\r" ending a line is not part of it, so the last
+// column of a line is its length plus one.
+func (c *textCursor) seek(line, column int) (int, error) {
+	if line < 1 || column < 1 {
+		return 0, fmt.Errorf("position %d:%d is before the start of the file", line, column)
+	}
+	if c.line == 0 {
+		c.line = 1
+		c.startLine(0)
+	}
+	for c.line < line {
+		i := strings.IndexByte(c.text[c.lineStart:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("line %d is past the end of the file", line)
+		}
+		c.line++
+		c.startLine(c.lineStart + i + 1)
+	}
+
+	units := column - 1
+	for c.units < units && c.pos < c.lineEnd {
+		r, size := utf8.DecodeRuneInString(c.text[c.pos:c.lineEnd])
+		n := 1
+		if utf16.RuneLen(r) == 2 {
+			n = 2
+		}
+		if c.units+n > units {
+			return 0, fmt.Errorf("position %d:%d splits a character", line, column)
+		}
+		c.units += n
+		c.pos += size
+	}
+	if c.units < units {
+		return 0, fmt.Errorf("column %d is past the end of line %d", column, line)
+	}
+	return c.pos, nil
+}
+
+func (c *textCursor) startLine(start int) {
+	c.lineStart, c.pos, c.units = start, start, 0
+	c.lineEnd = len(c.text)
+	if i := strings.IndexByte(c.text[start:], '\n'); i >= 0 {
+		c.lineEnd = start + i
+	}
+	if c.lineEnd > start && c.text[c.lineEnd-1] == '\r' {
+		c.lineEnd--
+	}
+}
@@ -0,0 +1,142 @@
+package filesystem
+
+import (
+	"strings"
+	"testing"
+	"worker/pkg/types"
+)
+
+func TestTextCursorSeek(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		line, column int
+		want         int
+		err          string
+	}{
+		{name: "start", text: "abc", line: 1, column: 1, want: 0},
+		{name: "end of line", text: "abc", line: 1, column: 4, want: 3},
+		{name: "past end of line", text: "abc", line: 1, column: 5, err: "past the end of line 1"},
+		{name: "second line", text: "ab\ncd", line: 2, column: 2, want: 4},
+		{name: "empty last line", text: "ab\n", line: 2, column: 1, want: 3},
+		{name: "past last line", text: "ab", line: 2, column: 1, err: "past the end of the file"},
+		{name: "line zero", text: "ab", line: 0, column: 1, err: "before the start"},
+		{name: "column zero", text: "ab", line: 1, column: 0, err: "before the start"},
+		// A "\r" ending a line is not part of it.
+		{name: "CRLF end of line", text: "ab\r\ncd", line: 1, column: 3, want: 2},
+		{name: "CRLF past end", text: "ab\r\ncd", line: 1, column: 4, err: "past the end of line 1"},
+		{name: "CRLF next line", text: "ab\r\ncd", line: 2, column: 1, want: 4},
+		{name: "lone CR last line", text: "ab\r", line: 1, column: 3, want: 2},
+		// Columns count UTF-16 units: "é" is one, "😀" a surrogate pair.
+		{name: "two byte rune", text: "éa", line: 1, column: 2, want: 2},
+		{name: "after surrogate pair", text: "a😀b", line: 1, column: 4, want: 5},
+		{name: "end after surrogate pair", text: "a😀b", line: 1, column: 5, want: 6},
+		{name: "inside surrogate pair", text: "a😀b", line: 1, column: 3, err: "splits a character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := textCursor{text: tt.text}
+			got, err := c.seek(tt.line, tt.column)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("seek(%d, %d) error = %v, want %q", tt.line, tt.column, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("seek(%d, %d) = %d, %v, want %d", tt.line, tt.column, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextCursorSeekInOrder(t *testing.T) {
+	text := "a😀b\r\nxyz\n\né"
+	positions := []struct{ line, column, want int }{
+		{1, 1, 0}, {1, 2, 1}, {1, 2, 1}, {1, 4, 5}, {1, 5, 6},
+		{2, 1, 8}, {2, 4, 11}, {3, 1, 12}, {4, 1, 13}, {4, 2, 15},
+	}
+	c := textCursor{text: text}
+	for _, p := range positions {
+		if got, err := c.seek(p.line, p.column); err != nil || got != p.want {
+			t.Errorf("seek(%d, %d) = %d, %v, want %d", p.line, p.column, got, err, p.want)
+		}
+	}
+}
+
+func TestPatchText(t *testing.T) {
+	edit := func(sl, sc, el, ec int, text string) types.TextEdit {
+		return types.TextEdit{
+			Range: types.TextRange{StartLineNumber: sl, StartColumn: sc, EndLineNumber: el, EndColumn: ec},
+			Text:  text,
+		}
+	}
+	tests := []struct {
+		name  string
+		text  string
+		edits []types.TextEdit
+		want  string
+		err   string
+	}{
+		{name: "no edits", text: "abc", want: "abc"},
+		{name: "replace", text: "hello world", edits: []types.TextEdit{edit(1, 7, 1, 12, "there")}, want: "hello there"},
+		{name: "insert", text: "ac", edits: []types.TextEdit{edit(1, 2, 1, 2, "b")}, want: "abc"},
+		{name: "delete across lines", text: "ab\ncd\nef", edits: []types.TextEdit{edit(1, 2, 3, 2, "")}, want: "af"},
+		{name: "join CRLF lines", text: "ab\r\ncd", edits: []types.TextEdit{edit(1, 3, 2, 1, " ")}, want: "ab cd"},
+		{name: "after surrogate pair", text: "😀a", edits: []types.TextEdit{edit(1, 3, 1, 4, "b")}, want: "😀b"},
+		// Every range refers to the original text, whatever the order.
+		{
+			name:  "ranges refer to the original",
+			text:  "one two three",
+			edits: []types.TextEdit{edit(1, 9, 1, 14, "3"), edit(1, 1, 1, 4, "1"), edit(1, 5, 1, 8, "2")},
+			want:  "1 2 3",
+		},
+		{
+			name:  "inserts at one point keep their order",
+			text:  "ac",
+			edits: []types.TextEdit{edit(1, 2, 1, 2, "1"), edit(1, 2, 1, 2, "2")},
+			want:  "a12c",
+		},
+		{
+			name:  "adjacent ranges",
+			text:  "abcd",
+			edits: []types.TextEdit{edit(1, 3, 1, 5, "Y"), edit(1, 1, 1, 3, "X")},
+			want:  "XY",
+		},
+		{
+			name:  "overlapping ranges",
+			text:  "abcd",
+			edits: []types.TextEdit{edit(1, 1, 1, 3, "X"), edit(1, 2, 1, 4, "Y")},
+			err:   "edits 1 and 2 overlap",
+		},
+		{
+			name:  "insert inside a range",
+			text:  "abcd",
+			edits: []types.TextEdit{edit(1, 1, 1, 4, "X"), edit(1, 2, 1, 2, "Y")},
+			err:   "overlap",
+		},
+		{name: "backwards range", text: "abcd", edits: []types.TextEdit{edit(1, 3, 1, 1, "")}, err: "edit 1: range ends before it starts"},
+		{name: "bad position", text: "ab", edits: []types.TextEdit{edit(1, 1, 1, 1, ""), edit(1, 1, 3, 1, "")}, err: "edit 2: line 3 is past the end"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, size, err := resolveEdits(tt.text, tt.edits)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := applyEdits(tt.text, resolved, size)
+			if got != tt.want {
+				t.Errorf("result = %q, want %q", got, tt.want)
+			}
+			if len(got) != size {
+				t.Errorf("size = %d, result is %d bytes", size, len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"worker/internal/tracing"
+	"worker/pkg/types"
+)
+
+// MaxReplaceFiles bounds how many files one replace may change.
+const MaxReplaceFiles = 1000
+
+// diffContext is the number of unchanged lines around each hunk of a preview.
+const diffContext = 3
+
+// ReplaceOptions describe a workspace-wide replace. The query and filters
+// are those of a search; matches never span lines.
+type ReplaceOptions struct {
+	SearchOptions
+	// Replacement is inserted for each match. For regex queries $1 or
+	// ${name} expand to capture groups, as in regexp.Expand.
+	Replacement string
+}
+
+// ContentVersion identifies a file's content, to detect changes made after
+// it was read.
+func ContentVersion(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// PreviewReplace finds the files a replace would change without changing
+// them, each with its version and a unified diff of the change.
+func (s *Service) PreviewReplace(ctx context.Context, opts ReplaceOptions) (_ []types.ReplaceFile, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.PreviewReplace", "query", opts.Query, "regex", opts.Regex)
+	defer span.EndErr(&err)
+
+	re, err := compileQuery(opts.SearchOptions)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, SearchTimeout)
+	defer cancel()
+
+	var files []types.ReplaceFile
+	err = s.searchFiles(ctx, opts.SearchOptions, func(rel string, content []byte) error {
+		lines, replaced, n := replaceLines(re, opts, content)
+		if n == 0 {
+			return nil
+		}
+		if len(files) == MaxReplaceFiles {
+			return fmt.Errorf("more than %d files match, narrow the search", MaxReplaceFiles)
+		}
+		path := filepath.ToSlash(filepath.Join("/workspace", rel))
+		files = append(files, types.ReplaceFile{
+			Path:         path,
+			Version:      ContentVersion(content),
+			Replacements: n,
+			Diff:         unifiedDiff(path, lines, replaced),
+		})
+		return nil
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, errors.New("search timed out, narrow it to preview the replace")
+	}
+	return files, err
+}
+
+// Replace applies a previewed replace to the confirmed files, which must
+// still be at the versions they were previewed at. Either every file is
+// changed or none is, and the change is committed once.
+func (s *Service) Replace(ctx context.Context, opts ReplaceOptions, confirmed []types.ReplaceFile) (_ []types.ReplaceFile, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.Replace", "query", opts.Query, "files", len(confirmed))
+	defer span.EndErr(&err)
+
+	re, err := compileQuery(opts.SearchOptions)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(confirmed) == 0 {
+		return nil, "", errors.New("no files to replace in")
+	}
+	if len(confirmed) > MaxReplaceFiles {
+		return nil, "", fmt.Errorf("cannot replace in more than %d files at once", MaxReplaceFiles)
+	}
+
+	// Checking the versions and writing is one step for other writers.
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var writes []pendingWrite
+	var applied []types.ReplaceFile
+	for _, file := range confirmed {
+		fullPath, err := s.securePath(file.Path)
+		if err != nil {
+			return nil, "", err
+		}
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			return nil, "", err
+		}
+		content, err := os.ReadFile(fullPath)
+		if err != nil {
+			return nil, "", err
+		}
+		if ContentVersion(content) != file.Version {
+			return nil, "", fmt.Errorf("%s changed since the preview, preview the replace again", file.Path)
+		}
+		_, replaced, n := replaceLines(re, opts, content)
+		if n == 0 {
+			continue
+		}
+		updated := []byte(strings.Join(replaced, "\n"))
+		writes = append(writes, pendingWrite{path: fullPath, mode: info.Mode().Perm(), old: content, new: updated})
+		applied = append(applied, types.ReplaceFile{Path: file.Path, Version: ContentVersion(updated), Replacements: n})
+	}
+	if len(writes) == 0 {
+		return nil, "", nil
+	}
+	if err := writeAll(writes); err != nil {
+		return nil, "", err
+	}
+
+	total := 0
+	for _, file := range applied {
+		total += file.Replacements
+	}
+	logger().Info("Replaced across workspace", "query", opts.Query, "files", len(applied), "replacements", total)
+	hash, err := s.commitChanges(ctx, ReplaceCommitMessage(opts, len(applied)))
+	return applied, hash, err
+}
+
+// ReplaceCommitMessage is the commit message of a replace across files.
+func ReplaceCommitMessage(opts ReplaceOptions, files int) string {
+	return fmt.Sprintf("FS_REPLACE: %q -> %q in %d files", opts.Query, opts.Replacement, files)
+}
+
+// replaceLines applies the replace to each line of content. It returns the
+// original and replaced lines, the latter joined by "\n" giving the new
+// content, and the number of replacements.
+func replaceLines(re *regexp.Regexp, opts ReplaceOptions, content []byte) (lines, replaced []string, n int) {
+	lines = strings.Split(string(content), "\n")
+	replaced = make([]string, len(lines))
+	for i, line := range lines {
+		text, cr := strings.CutSuffix(line, "\r")
+		locs := matchesIn(re, []byte(text))
+		if len(locs) == 0 {
+			replaced[i] = line
+			continue
+		}
+		var out []byte
+		last := 0
+		for _, loc := range locs {
+			out = append(out, text[last:loc[0]]...)
+			if opts.Regex {
+				out = re.ExpandString(out, opts.Replacement, text, loc)
+			} else {
+				out = append(out, opts.Replacement...)
+			}
+			last = loc[1]
+		}
+		out = append(out, text[last:]...)
+		if cr {
+			out = append(out, '\r')
+		}
+		replaced[i] = string(out)
+		n += len(locs)
+	}
+	return lines, replaced, n
+}
+
+// unifiedDiff renders the change from lines to replaced, which pair up one
+// to one (a replaced line may contain newlines), as a unified diff.
+func unifiedDiff(path string, lines, replaced []string) string {
+	// A trailing newline leaves an empty last line that is not a line at all.
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines, replaced = lines[:len(lines)-1], replaced[:len(replaced)-1]
+	}
+	// newStart[i] is the 1-based line of the new file where old line i starts.
+	newStart := make([]int, len(lines)+1)
+	newStart[0] = 1
+	for i, r := range replaced {
+		newStart[i+1] = newStart[i] + strings.Count(r, "\n") + 1
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a%s\n+++ b%s\n", strings.TrimPrefix(path, "/workspace"), strings.TrimPrefix(path, "/workspace"))
+	for i := 0; i < len(lines); {
+		if lines[i] == replaced[i] {
+			i++
+			continue
+		}
+		// Extend the hunk while the next change is close enough to share context.
+		start := max(i-diffContext, 0)
+		end := i + 1
+		for j := end; j < len(lines) && j < end+2*diffContext; j++ {
+			if lines[j] != replaced[j] {
+				end = j + 1
+			}
+		}
+		stop := min(end+diffContext, len(lines))
+		var hunk bytes.Buffer
+		newCount := 0
+		for k := start; k < stop; k++ {
+			if lines[k] == replaced[k] {
+				fmt.Fprintf(&hunk, " %s\n", lines[k])
+				newCount++
+				continue
+			}
+			fmt.Fprintf(&hunk, "-%s\n", lines[k])
+			for _, r := range strings.Split(replaced[k], "\n") {
+				fmt.Fprintf(&hunk, "+%s\n", r)
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", start+1, stop-start, newStart[start], newCount)
+		b.Write(hunk.Bytes())
+		i = stop
+	}
+	return b.String()
+}
+
+// pendingWrite is one file of a multi-file write.
+type pendingWrite struct {
+	path     string
+	mode     os.FileMode
+	old, new []byte
+	tmp      string
+}
+
+// writeAll replaces the content of several files as one change. Each new
+// content is first written to a temporary file beside the original, and only
+// once all are written are they renamed into place. If a rename fails, the
+// files already replaced get their old content back.
+func writeAll(writes []pendingWrite) (err error) {
+	defer func() {
+		if err != nil {
+			for _, w := range writes {
+				if w.tmp != "" {
+					os.Remove(w.tmp)
+				}
+			}
+		}
+	}()
+	for i := range writes {
+		w := &writes[i]
+		f, err := os.CreateTemp(filepath.Dir(w.path), stagingPattern(filepath.Base(w.path)))
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", w.path, err)
+		}
+		w.tmp = f.Name()
+		_, err = f.Write(w.new)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(w.tmp, w.mode)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", w.path, err)
+		}
+	}
+	for i := range writes {
+		if err := os.Rename(writes[i].tmp, writes[i].path); err != nil {
+			for _, done := range writes[:i] {
+				if restoreErr := os.WriteFile(done.path, done.old, done.mode); restoreErr != nil {
+					logger().Error("Failed to restore file after a failed replace", "path", done.path, "error", restoreErr)
+				}
+			}
+			return fmt.Errorf("failed to replace %s: %w", writes[i].path, err)
+		}
+		writes[i].tmp = ""
+	}
+	return nil
+}
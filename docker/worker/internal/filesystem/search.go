@@ -0,0 +1,252 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+	"worker/internal/tracing"
+	"worker/pkg/types"
+)
+
+// Search limits.
+const (
+	DefaultSearchResults = 1000
+	MaxSearchResults     = 10000
+	// SearchTimeout bounds a whole search; what was found by then is
+	// returned as truncated. The bridge waits a little longer for the ack.
+	SearchTimeout = 30 * time.Second
+
+	maxSearchFileSize = 4 << 20 // Larger files are skipped
+	binarySniffSize   = 8000    // Bytes checked for NUL, as git does
+	searchBatchSize   = 100     // Matches per batch
+	searchFlushPeriod = 200 * time.Millisecond
+	maxPreviewBytes   = 250
+	previewContext    = 40 // Bytes kept before a match in a long line
+)
+
+// SearchOptions describe a content search.
+type SearchOptions struct {
+	Query         string
+	Regex         bool // Query is an RE2 regular expression rather than literal text
+	CaseSensitive bool
+	Include       []string // Only files matching one of these globs
+	Exclude       []string // Files and folders matching these globs are skipped
+	MaxResults    int
+	TargetPath    string // Folder to search, the workspace root when empty
+}
+
+// SearchResult summarizes a finished search.
+type SearchResult struct {
+	Matches   int
+	Files     int // Files with at least one match
+	Searched  int // Files searched
+	Truncated bool
+}
+
+// errSearchFull stops the walk once enough matches are found.
+var errSearchFull = errors.New("search result limit reached")
+
+// Search finds opts.Query in the workspace, passing matches to onBatch in
+// batches as they are found. Like the file explorer it skips .git, and it also
+// skips binary files and anything .gitignore excludes. Cancelling ctx stops
+// the search and returns ctx.Err().
+func (s *Service) Search(ctx context.Context, opts SearchOptions, onBatch func(matches []types.SearchMatch)) (_ SearchResult, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.Search", "query", opts.Query, "regex", opts.Regex)
+	defer span.EndErr(&err)
+
+	re, err := compileQuery(opts)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	maxResults := opts.MaxResults
+	if maxResults <= 0 {
+		maxResults = DefaultSearchResults
+	}
+	maxResults = min(maxResults, MaxSearchResults)
+
+	ctx, cancel := context.WithTimeout(ctx, SearchTimeout)
+	defer cancel()
+
+	var result SearchResult
+	var batch []types.SearchMatch
+	lastFlush := time.Now()
+	flush := func() {
+		if len(batch) > 0 {
+			onBatch(batch)
+			batch = nil
+		}
+		lastFlush = time.Now()
+	}
+
+	err = s.searchFiles(ctx, opts, func(rel string, content []byte) error {
+		result.Searched++
+		found := false
+		for lineNo, line := range bytes.Split(content, []byte("\n")) {
+			line = bytes.TrimSuffix(line, []byte("\r"))
+			for _, loc := range matchesIn(re, line) {
+				if result.Matches == maxResults {
+					result.Truncated = true
+					return errSearchFull
+				}
+				if !found {
+					found = true
+					result.Files++
+				}
+				result.Matches++
+				batch = append(batch, types.SearchMatch{
+					Path:    filepath.ToSlash(filepath.Join("/workspace", rel)),
+					Line:    lineNo + 1,
+					Column:  utf16Len(line[:loc[0]]) + 1,
+					Length:  utf16Len(line[loc[0]:loc[1]]),
+					Preview: preview(line, loc[0]),
+				})
+				if len(batch) == searchBatchSize {
+					flush()
+				}
+			}
+		}
+		if time.Since(lastFlush) >= searchFlushPeriod {
+			flush()
+		}
+		return nil
+	})
+	flush()
+
+	switch {
+	case errors.Is(err, errSearchFull):
+		err = nil
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil:
+		logger().Warn("Search timed out, returning partial results", "query", opts.Query, "matches", result.Matches)
+		result.Truncated = true
+		err = nil
+	}
+	return result, err
+}
+
+// compileQuery turns the query of opts into a regular expression.
+func compileQuery(opts SearchOptions) (*regexp.Regexp, error) {
+	if opts.Query == "" {
+		return nil, errors.New("query is required")
+	}
+	pattern := opts.Query
+	if !opts.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if !opts.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	return re, nil
+}
+
+// matchesIn returns the submatch indexes of every non-empty match in line.
+// Empty matches of patterns like "a*" are noise.
+func matchesIn(re *regexp.Regexp, line []byte) [][]int {
+	locs := re.FindAllSubmatchIndex(line, -1)
+	n := 0
+	for _, loc := range locs {
+		if loc[0] != loc[1] {
+			locs[n] = loc
+			n++
+		}
+	}
+	return locs[:n]
+}
+
+// searchFiles calls fn with the content of every text file a search covers:
+// those below opts.TargetPath that match its globs, are not ignored, and are
+// not binary. It stops early if ctx is cancelled.
+func (s *Service) searchFiles(ctx context.Context, opts SearchOptions, fn func(rel string, content []byte) error) error {
+	root, err := s.securePath(opts.TargetPath)
+	if err != nil {
+		return err
+	}
+	rootRel, _ := filepath.Rel(s.baseDir, root)
+	rootRel = filepath.ToSlash(rootRel)
+	if rootRel == "." {
+		rootRel = ""
+	}
+	include, exclude := compileGlobs(opts.Include), compileGlobs(opts.Exclude)
+	return s.walkWorkspace(root, rootRel, s.ignoreRulesFor(rootRel), func(full, rel string, entry os.DirEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if exclude.match(rel, entry.IsDir()) {
+			// Excluding a folder such as "node_modules" excludes its contents.
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || len(include) > 0 && !include.match(rel, false) {
+			return nil
+		}
+		content, ok := readSearchable(full)
+		if !ok {
+			return nil
+		}
+		return fn(rel, content)
+	})
+}
+
+// readSearchable reads a text file for searching. It reports false for
+// unreadable, oversized and binary files.
+func readSearchable(path string) ([]byte, bool) {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > maxSearchFileSize {
+		return nil, false
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	if bytes.IndexByte(content[:min(len(content), binarySniffSize)], 0) >= 0 {
+		return nil, false
+	}
+	return content, true
+}
+
+// preview returns the line around a match at byte offset start, shortened
+// to maxPreviewBytes without splitting a UTF-8 sequence.
+func preview(line []byte, start int) string {
+	if len(line) <= maxPreviewBytes {
+		return string(line)
+	}
+	from := 0
+	if start > previewContext {
+		from = start - previewContext
+		for from < start && !utf8.RuneStart(line[from]) {
+			from++
+		}
+	}
+	to := min(from+maxPreviewBytes, len(line))
+	for to < len(line) && to > from && !utf8.RuneStart(line[to]) {
+		to--
+	}
+	return string(line[from:to])
+}
+
+// utf16Len returns the length of b in UTF-16 code units.
+func utf16Len(b []byte) int {
+	n := 0
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if utf16.RuneLen(r) == 2 {
+			n += 2
+		} else {
+			n++
+		}
+		b = b[size:]
+	}
+	return n
+}
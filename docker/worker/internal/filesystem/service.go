@@ -1,22 +1,50 @@
 package filesystem
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"mime"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
+	"worker/internal/logging"
+	"worker/internal/metrics"
+	"worker/internal/tracing"
 	"worker/pkg/types"
 )
 
+var gitCommitDuration = metrics.NewHistogramVec("worker_git_commit_duration_seconds", "Duration of the add/commit/rev-parse sequence in commitChanges, by outcome.", nil, "outcome")
+
 type Service struct {
 	baseDir    string // The root workspace directory
 	mode       string // "RECORDING" or "PLAYBACK"
 	gitInitMux sync.Mutex // Mutex to ensure git is only initialized once
 	gitInited  bool // Track if git has been initialized
+
+	transferMu    sync.Mutex
+	maxInlineSize int64              // Largest file read or written in one message
+	maxUploadSize int64              // Largest file written in chunks
+	dataDir       string             // Worker-owned directory outside the workspace
+	uploads       map[string]*upload // Chunked writes in progress, by full path
+	expireTimer   *time.Timer        // Set while uploads are in progress
+
+	writeMu sync.Mutex // Makes checking a file's version and writing it one step
+
+	trashMu     sync.Mutex
+	trashLimits TrashLimits
+}
+
+func logger() *slog.Logger {
+	return slog.With(logging.KeyComponent, "filesystem")
 }
 
 func (s *Service) securePath(relativePath string) (string, error) {
@@ -40,27 +68,68 @@ func (s *Service) securePath(relativePath string) (string, error) {
 	return filepath.Join(s.baseDir, cleanPath), nil
 }
 
+// SecurePath resolves a /workspace-relative path to an absolute path inside
+// the workspace, rejecting paths that escape it.
+func (s *Service) SecurePath(relativePath string) (string, error) {
+	return s.securePath(relativePath)
+}
+
 func NewService(baseDir string) *Service {
-	return &Service{baseDir: baseDir}
+	return &Service{
+		baseDir:       baseDir,
+		maxInlineSize: DefaultMaxInlineSize,
+		maxUploadSize: DefaultMaxUploadSize,
+		uploads:       make(map[string]*upload),
+		trashLimits:   DefaultTrashLimits,
+	}
 }
 
 func (s *Service) GetBaseDir() string {
 	return s.baseDir
 }
 
-func (s *Service) ReadFolder(relativePath string) ([]types.DirectoryEntry, error) {
+// Limits of ReadFolderTree.
+const (
+	MaxReadFolderDepth   = 10
+	MaxReadFolderEntries = 10000
+)
+
+func (s *Service) ReadFolder(ctx context.Context, relativePath string) ([]types.DirectoryEntry, error) {
+	return s.ReadFolderTree(ctx, relativePath, 1)
+}
+
+// ReadFolderTree lists a folder and, down to depth levels, the folders inside
+// it. Once MaxReadFolderEntries entries are listed, deeper folders are
+// returned without their children.
+func (s *Service) ReadFolderTree(ctx context.Context, relativePath string, depth int) (_ []types.DirectoryEntry, err error) {
+	_, span := tracing.Start(ctx, "filesystem.ReadFolder", "path", relativePath, "depth", depth)
+	defer span.EndErr(&err)
+
 	fullPath, err := s.securePath(relativePath)
 	if err != nil { return nil, err }
 
+	// Construct the path relative to /workspace
+	entryRelativePath := strings.TrimPrefix(relativePath, "/workspace")
+	if entryRelativePath == "" {
+		entryRelativePath = "/"
+	}
+	depth = min(max(depth, 1), MaxReadFolderDepth)
+	budget := MaxReadFolderEntries
+	return readFolder(fullPath, filepath.Join("/workspace", entryRelativePath), depth, &budget)
+}
+
+// readFolder lists the folder at fullPath, whose /workspace path is
+// workspacePath, and its subfolders down to depth levels while budget lasts.
+func readFolder(fullPath, workspacePath string, depth int, budget *int) ([]types.DirectoryEntry, error) {
 	entries, err := os.ReadDir(fullPath)
 	if err != nil {
 		return nil, err
 	}
 
-	var dirEntries []types.DirectoryEntry
+	dirEntries := []types.DirectoryEntry{}
 	for _, entry := range entries {
-		// Filter out .git directory
-		if entry.Name() == ".git" {
+		// Filter out .git directory and anything being staged
+		if entry.Name() == ".git" || strings.HasPrefix(entry.Name(), stagingPrefix) {
 			continue
 		}
 
@@ -69,23 +138,80 @@ func (s *Service) ReadFolder(relativePath string) ([]types.DirectoryEntry, error
 			entryType = "directory"
 		}
 
-		// Construct the path relative to /workspace
-		entryRelativePath := strings.TrimPrefix(relativePath, "/workspace")
-		if entryRelativePath == "" {
-			entryRelativePath = "/"
-		}
-		entryPath := filepath.Join("/workspace", entryRelativePath, entry.Name())
-
 		dirEntries = append(dirEntries, types.DirectoryEntry{
 			Type: entryType,
-			Path: filepath.ToSlash(entryPath), // Ensure forward slashes for consistency
+			Path: filepath.ToSlash(filepath.Join(workspacePath, entry.Name())), // Ensure forward slashes for consistency
 			Name: entry.Name(),
+			Stat: statFile(filepath.Join(fullPath, entry.Name())),
 		})
 	}
+	*budget -= len(dirEntries)
+
+	if depth > 1 {
+		for i := range dirEntries {
+			if dirEntries[i].Type != "directory" || *budget <= 0 {
+				continue
+			}
+			children, err := readFolder(filepath.Join(fullPath, dirEntries[i].Name), dirEntries[i].Path, depth-1, budget)
+			if err != nil {
+				logger().Debug("Skipping unreadable folder", "path", dirEntries[i].Path, "error", err)
+				continue
+			}
+			dirEntries[i].Children = children
+		}
+	}
 	return dirEntries, nil
 }
 
-func (s *Service) ReadFile(relativePath string) (string, error) {
+// Stat describes one file or folder.
+func (s *Service) Stat(ctx context.Context, relativePath string) (_ types.DirectoryEntry, err error) {
+	_, span := tracing.Start(ctx, "filesystem.Stat", "path", relativePath)
+	defer span.EndErr(&err)
+
+	fullPath, err := s.securePath(relativePath)
+	if err != nil { return types.DirectoryEntry{}, err }
+
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return types.DirectoryEntry{}, err
+	}
+	entryType := "file"
+	if info.IsDir() {
+		entryType = "directory"
+	}
+	rel, _ := filepath.Rel(s.baseDir, fullPath)
+	return types.DirectoryEntry{
+		Type: entryType,
+		Path: filepath.ToSlash(filepath.Join("/workspace", rel)),
+		Name: filepath.Base(filepath.Join("/workspace", rel)),
+		Stat: statFile(fullPath),
+	}, nil
+}
+
+// statFile returns the metadata of the file at fullPath, or nil if it has
+// gone.
+func statFile(fullPath string) *types.FileStat {
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return nil
+	}
+	stat := &types.FileStat{
+		Size:       info.Size(),
+		ModifiedMs: info.ModTime().UnixMilli(),
+		Mode:       fmt.Sprintf("%04o", info.Mode().Perm()),
+		Executable: info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0,
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		stat.Symlink = true
+		stat.Target, _ = os.Readlink(fullPath)
+	}
+	return stat
+}
+
+func (s *Service) ReadFile(ctx context.Context, relativePath string) (_ string, err error) {
+	_, span := tracing.Start(ctx, "filesystem.ReadFile", "path", relativePath)
+	defer span.EndErr(&err)
+
 	fullPath, err := s.securePath(relativePath)
 	if err != nil { return "", err }
 
@@ -96,19 +222,111 @@ func (s *Service) ReadFile(relativePath string) (string, error) {
 	return string(content), nil
 }
 
-func (s *Service) CreateFile(relativePath string, content string) (string, error) {
+// Content encodings of the file APIs.
+const (
+	EncodingUTF8   = "utf-8"
+	EncodingBase64 = "base64"
+)
+
+// ReadFileEncoded reads a file for the editor. Text comes back as is; any
+// other content, such as an image, font or database, comes back as base64 so
+// that it survives JSON.
+func (s *Service) ReadFileEncoded(ctx context.Context, relativePath string) (_ types.FileContent, err error) {
+	_, span := tracing.Start(ctx, "filesystem.ReadFileEncoded", "path", relativePath)
+	defer span.EndErr(&err)
+
+	fullPath, err := s.securePath(relativePath)
+	if err != nil { return types.FileContent{}, err }
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return types.FileContent{}, err
+	}
+	if err := s.checkInlineSize(relativePath, info.Size()); err != nil {
+		return types.FileContent{}, err
+	}
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return types.FileContent{}, err
+	}
+	return encodeFile(fullPath, content), nil
+}
+
+// encodeFile describes content read from fullPath, encoding it as base64 if
+// it is binary.
+func encodeFile(fullPath string, content []byte) types.FileContent {
+	file := types.FileContent{
+		Content:  string(content),
+		Encoding: EncodingUTF8,
+		MimeType: detectMimeType(fullPath, content),
+		Size:     int64(len(content)),
+		Version:  ContentVersion(content),
+	}
+	if isBinary(content) {
+		file.Content = base64.StdEncoding.EncodeToString(content)
+		file.Encoding = EncodingBase64
+	}
+	return file
+}
+
+// DecodeContent returns the raw content of a file sent with encoding, which
+// is empty or "utf-8" for text and "base64" for anything else.
+func DecodeContent(content, encoding string) (string, error) {
+	switch encoding {
+	case "", EncodingUTF8:
+		return content, nil
+	case EncodingBase64:
+		decoded, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return "", fmt.Errorf("invalid base64 content: %w", err)
+		}
+		return string(decoded), nil
+	default:
+		return "", fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+// isBinary reports whether content cannot be sent as text: it has a NUL byte
+// near the start, as git checks, or is not valid UTF-8.
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), binarySniffSize)], 0) >= 0 || !utf8.Valid(content)
+}
+
+// detectMimeType names the type of a file by its extension, or failing that
+// by sniffing its content.
+func detectMimeType(path string, content []byte) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(path)); mimeType != "" {
+		return mimeType
+	}
+	return http.DetectContentType(content)
+}
+
+func (s *Service) CreateFile(ctx context.Context, relativePath string, content string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.CreateFile", "path", relativePath, "bytes", len(content))
+	defer span.EndErr(&err)
+
 	fullPath, err := s.securePath(relativePath)
 	if err != nil { return "", err }
+	if err := s.checkInlineSize(relativePath, int64(len(content))); err != nil {
+		return "", err
+	}
 
+	// Create missing parent folders, as hydration does.
+	if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", filepath.Dir(fullPath), err)
+	}
 	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
 		return "", err
 	}
 
 	// Commit the change and return the hash
-	return s.commitChanges(fmt.Sprintf("FS_CREATE_FILE: %s", relativePath))
+	return s.commitChanges(ctx, fmt.Sprintf("FS_CREATE_FILE: %s", relativePath))
 }
 
-func (s *Service) CreateFileBase64(relativePath string, contentBase64 string) (string, error) {
+func (s *Service) CreateFileBase64(ctx context.Context, relativePath string, contentBase64 string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.CreateFileBase64", "path", relativePath)
+	defer span.EndErr(&err)
+
 	fullPath, err := s.securePath(relativePath)
 	if err != nil { return "", err }
 
@@ -130,10 +348,13 @@ func (s *Service) CreateFileBase64(relativePath string, contentBase64 string) (s
 	}
 
 	// Commit the change and return the hash
-	return s.commitChanges(fmt.Sprintf("FS_HYDRATE_FILE: %s", relativePath))
+	return s.commitChanges(ctx, fmt.Sprintf("FS_HYDRATE_FILE: %s", relativePath))
 }
 
-func (s *Service) CreateFolder(relativePath string) (string, error) {
+func (s *Service) CreateFolder(ctx context.Context, relativePath string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.CreateFolder", "path", relativePath)
+	defer span.EndErr(&err)
+
 	fullPath, err := s.securePath(relativePath)
 	if err != nil { return "", err }
 
@@ -142,34 +363,101 @@ func (s *Service) CreateFolder(relativePath string) (string, error) {
 	}
 
 	// Commit the change and return the hash
-	return s.commitChanges(fmt.Sprintf("FS_CREATE_FOLDER: %s", relativePath))
+	return s.commitChanges(ctx, fmt.Sprintf("FS_CREATE_FOLDER: %s", relativePath))
 }
 
-func (s *Service) UpdateFile(relativePath string, content string) (string, error) {
+// UpdateFile overwrites a file. If expectedVersion is set, the file must
+// still be at that version, as returned when it was read, or the update fails
+// with a VersionConflictError.
+func (s *Service) UpdateFile(ctx context.Context, relativePath string, content string, expectedVersion string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.UpdateFile", "path", relativePath, "bytes", len(content))
+	defer span.EndErr(&err)
+
 	fullPath, err := s.securePath(relativePath)
 	if err != nil { return "", err }
+	if err := s.checkInlineSize(relativePath, int64(len(content))); err != nil {
+		return "", err
+	}
 
-	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+	s.writeMu.Lock()
+	err = s.checkVersion(relativePath, fullPath, expectedVersion)
+	if err == nil {
+		err = os.WriteFile(fullPath, []byte(content), 0644)
+	}
+	s.writeMu.Unlock()
+	if err != nil {
 		return "", err
 	}
 
 	// Commit the change and return the hash
-	return s.commitChanges(fmt.Sprintf("FS_UPDATE_FILE: %s", relativePath))
+	return s.commitChanges(ctx, fmt.Sprintf("FS_UPDATE_FILE: %s", relativePath))
 }
 
-func (s *Service) DeleteResource(relativePath string) (string, error) {
+// VersionConflictError is returned when a file changed after the version a
+// write was based on, for example by a formatter run in the terminal.
+// Current is the file as it is now, so the editor can merge; its content is
+// left out if it is over the inline size limit.
+type VersionConflictError struct {
+	Path    string
+	Current types.FileContent
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s changed since it was read, merge with the current version and save again", e.Path)
+}
+
+// checkVersion fails with a VersionConflictError unless the file at fullPath
+// is at expectedVersion. An empty expectedVersion skips the check. The caller
+// holds writeMu.
+func (s *Service) checkVersion(relativePath, fullPath, expectedVersion string) error {
+	if expectedVersion == "" {
+		return nil
+	}
+	current, err := os.ReadFile(fullPath)
+	if err != nil {
+		return err
+	}
+	if ContentVersion(current) != expectedVersion {
+		conflict := &VersionConflictError{Path: relativePath, Current: encodeFile(fullPath, current)}
+		if s.checkInlineSize(relativePath, int64(len(current))) != nil {
+			conflict.Current.Content = ""
+		}
+		return conflict
+	}
+	return nil
+}
+
+// DeleteResource moves a file or folder to the trash, from which
+// RestoreResource can bring it back, unless it is too large for the trash.
+// Deleting something already gone does nothing.
+func (s *Service) DeleteResource(ctx context.Context, relativePath string) (_ Deletion, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.DeleteResource", "path", relativePath)
+	defer span.EndErr(&err)
+
 	fullPath, err := s.securePath(relativePath)
-	if err != nil { return "", err }
+	if err != nil { return Deletion{}, "", err }
+	if fullPath == s.baseDir {
+		return Deletion{}, "", errors.New("cannot delete the workspace root")
+	}
 
-	if err := os.RemoveAll(fullPath); err != nil {
-		return "", err
+	var deletion Deletion
+	if _, err := os.Lstat(fullPath); err == nil {
+		if deletion, err = s.moveToTrash(relativePath, fullPath); err != nil {
+			return Deletion{}, "", fmt.Errorf("failed to move %s to the trash: %w", relativePath, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return Deletion{}, "", err
 	}
 
 	// Commit the change and return the hash
-	return s.commitChanges(fmt.Sprintf("FS_DELETE_RESOURCE: %s", relativePath))
+	hash, err := s.commitChanges(ctx, fmt.Sprintf("FS_DELETE_RESOURCE: %s", relativePath))
+	return deletion, hash, err
 }
 
-func (s *Service) MoveResource(oldPath, newRelativePath string) (string, error) {
+func (s *Service) MoveResource(ctx context.Context, oldPath, newRelativePath string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.MoveResource", "path", oldPath, "newPath", newRelativePath)
+	defer span.EndErr(&err)
+
 	oldFullPath, err := s.securePath(oldPath)
 	if err != nil { return "", err }
 	newFullPath, err := s.securePath(newRelativePath)
@@ -180,7 +468,7 @@ func (s *Service) MoveResource(oldPath, newRelativePath string) (string, error)
 	}
 
 	// Commit the change and return the hash
-	return s.commitChanges(fmt.Sprintf("FS_MOVE_RESOURCE: %s -> %s", oldPath, newRelativePath))
+	return s.commitChanges(ctx, fmt.Sprintf("FS_MOVE_RESOURCE: %s -> %s", oldPath, newRelativePath))
 }
 
 // ============================================================================
@@ -188,7 +476,10 @@ func (s *Service) MoveResource(oldPath, newRelativePath string) (string, error)
 // ============================================================================
 
 // executeGitCommand runs a git command in the workspace directory
-func (s *Service) executeGitCommand(args ...string) (string, error) {
+func (s *Service) executeGitCommand(ctx context.Context, args ...string) (_ string, err error) {
+	_, span := tracing.Start(ctx, "git "+args[0], "args", strings.Join(args, " "))
+	defer span.EndErr(&err)
+
 	cmd := exec.Command("git", args...)
 	cmd.Dir = s.baseDir
 	output, err := cmd.CombinedOutput()
@@ -199,7 +490,10 @@ func (s *Service) executeGitCommand(args ...string) (string, error) {
 }
 
 // InitializeGit sets up the git repository based on the mode
-func (s *Service) InitializeGit(mode string) error {
+func (s *Service) InitializeGit(ctx context.Context, mode string) (err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.InitializeGit", "mode", mode)
+	defer span.EndErr(&err)
+
 	s.gitInitMux.Lock()
 	defer s.gitInitMux.Unlock()
 
@@ -209,7 +503,7 @@ func (s *Service) InitializeGit(mode string) error {
 	// In RECORDING mode, always reinitialize (remove existing and start fresh)
 	// In PLAYBACK mode, skip if already initialized
 	if s.gitInited && mode != "RECORDING" {
-		log.Printf("[FS] Git already initialized, skipping")
+		logger().Debug("Git already initialized, skipping")
 		return nil
 	}
 
@@ -221,28 +515,28 @@ func (s *Service) InitializeGit(mode string) error {
 	if mode == "RECORDING" {
 		// RECORDING MODE: Always start fresh
 		if _, err := os.Stat(gitDir); err == nil {
-			log.Println("[FS] RECORDING mode: Removing existing .git directory for fresh recording")
+			logger().Info("RECORDING mode: removing existing .git directory for fresh recording")
 			if err := os.RemoveAll(gitDir); err != nil {
 				return fmt.Errorf("failed to remove existing .git directory: %w", err)
 			}
 		}
 
-		log.Println("[FS] RECORDING mode: Initializing fresh git repository")
+		logger().Info("RECORDING mode: initializing fresh git repository")
 
 		// Initialize git repository
-		if _, err := s.executeGitCommand("init"); err != nil {
+		if _, err := s.executeGitCommand(ctx, "init"); err != nil {
 			return fmt.Errorf("git init failed: %w", err)
 		}
 
 		// Note: We don't create an initial commit here
 		// Commits will only be created when files are actually modified
-		log.Println("[FS] ✅ Git repository initialized successfully for RECORDING (no initial commit)")
+		logger().Info("Git repository initialized for RECORDING (no initial commit)")
 	} else {
 		// PLAYBACK MODE: Use hydrated .git directory
 		if _, err := os.Stat(gitDir); err != nil {
-			log.Println("[FS] PLAYBACK mode: No .git directory found - will be hydrated from S3")
+			logger().Info("PLAYBACK mode: no .git directory found, will be hydrated from S3")
 		} else {
-			log.Println("[FS] PLAYBACK mode: Using existing .git directory from hydration")
+			logger().Info("PLAYBACK mode: using existing .git directory from hydration")
 		}
 	}
 
@@ -252,110 +546,130 @@ func (s *Service) InitializeGit(mode string) error {
 
 // CreateInitialCommit creates the initial commit for the workspace
 // This captures the current state of all files as the starting point for recording
-func (s *Service) CreateInitialCommit() (string, error) {
+func (s *Service) CreateInitialCommit(ctx context.Context) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.CreateInitialCommit")
+	defer span.EndErr(&err)
+
 	// Only commit in RECORDING mode
 	if s.mode != "RECORDING" {
 		return "", nil
 	}
 
 	// Add all files
-	if _, err := s.executeGitCommand("add", "."); err != nil {
+	if _, err := s.executeGitCommand(ctx, gitAddArgs...); err != nil {
 		return "", fmt.Errorf("git add failed: %w", err)
 	}
 
 	// Create initial commit
-	if _, err := s.executeGitCommand("commit", "-m", "Initial workspace snapshot"); err != nil {
+	if _, err := s.executeGitCommand(ctx, "commit", "-m", "Initial workspace snapshot"); err != nil {
 		// If nothing to commit, return empty hash (not an error)
 		if strings.Contains(err.Error(), "nothing to commit") {
-			log.Println("[FS] No files to commit in initial snapshot")
+			logger().Info("No files to commit in initial snapshot")
 			return "", nil
 		}
 		return "", fmt.Errorf("git commit failed: %w", err)
 	}
 
 	// Get the commit hash
-	hash, err := s.executeGitCommand("rev-parse", "HEAD")
+	hash, err := s.executeGitCommand(ctx, "rev-parse", "HEAD")
 	if err != nil {
 		return "", fmt.Errorf("git rev-parse failed: %w", err)
 	}
 
-	log.Printf("[FS] ✅ Initial commit created: %s", hash[:8])
+	logger().Info("Initial commit created", "hash", hash[:8])
 	return hash, nil
 }
 
 // commitChanges creates a git commit with the given message and returns the commit hash
 // In PLAYBACK mode, this is a no-op and returns empty string
-func (s *Service) commitChanges(message string) (string, error) {
+func (s *Service) commitChanges(ctx context.Context, message string) (_ string, err error) {
 	// Only commit in RECORDING mode
 	if s.mode != "RECORDING" {
 		return "", nil // Silent no-op in PLAYBACK mode
 	}
 
+	ctx, span := tracing.Start(ctx, "filesystem.commitChanges", "message", message)
+	start := time.Now()
+	outcome := "error"
+	defer func() {
+		gitCommitDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+		span.SetAttributes("outcome", outcome)
+		span.EndErr(&err)
+	}()
+
 	// Add all changes
-	if _, err := s.executeGitCommand("add", "."); err != nil {
+	if _, err := s.executeGitCommand(ctx, gitAddArgs...); err != nil {
 		return "", fmt.Errorf("git add failed: %w", err)
 	}
 
 	// Commit changes
-	if _, err := s.executeGitCommand("commit", "-m", message); err != nil {
+	if _, err := s.executeGitCommand(ctx, "commit", "-m", message); err != nil {
 		// If nothing to commit, return empty hash (not an error)
 		if strings.Contains(err.Error(), "nothing to commit") {
-			log.Printf("[FS] No changes to commit for: %s", message)
+			logger().Debug("No changes to commit", "message", message)
+			outcome = "no_changes"
 			return "", nil
 		}
 		return "", fmt.Errorf("git commit failed: %w", err)
 	}
 
 	// Get the commit hash
-	hash, err := s.executeGitCommand("rev-parse", "HEAD")
+	hash, err := s.executeGitCommand(ctx, "rev-parse", "HEAD")
 	if err != nil {
 		return "", fmt.Errorf("git rev-parse failed: %w", err)
 	}
 
-	log.Printf("[FS] ✅ Committed: %s (hash: %s)", message, hash[:8])
+	outcome = "committed"
+	logger().Info("Committed", "message", message, "hash", hash[:8])
 	return hash, nil
 }
 
 // CommitChanges creates a git commit for interactive changes (works in both RECORDING and PLAYBACK modes)
 // This is used when the user makes changes during pause in PLAYBACK mode
-func (s *Service) CommitChanges(message string, mode string) (string, error) {
+func (s *Service) CommitChanges(ctx context.Context, message string, mode string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.CommitChanges", "message", message)
+	defer span.EndErr(&err)
+
 	if !s.gitInited {
 		return "", fmt.Errorf("git not initialized")
 	}
 
 	// Add all changes
-	if _, err := s.executeGitCommand("add", "."); err != nil {
+	if _, err := s.executeGitCommand(ctx, gitAddArgs...); err != nil {
 		return "", fmt.Errorf("git add failed: %w", err)
 	}
 
 	// Commit changes
-	if _, err := s.executeGitCommand("commit", "-m", message); err != nil {
+	if _, err := s.executeGitCommand(ctx, "commit", "-m", message); err != nil {
 		// If nothing to commit, return empty hash (not an error)
 		if strings.Contains(err.Error(), "nothing to commit") {
-			log.Printf("[FS] No changes to commit for: %s", message)
+			logger().Debug("No changes to commit", "message", message)
 			return "", nil
 		}
 		return "", fmt.Errorf("git commit failed: %w", err)
 	}
 
 	// Get the commit hash
-	hash, err := s.executeGitCommand("rev-parse", "HEAD")
+	hash, err := s.executeGitCommand(ctx, "rev-parse", "HEAD")
 	if err != nil {
 		return "", fmt.Errorf("git rev-parse failed: %w", err)
 	}
 
-	log.Printf("[FS] ✅ Committed interactive changes: %s (hash: %s)", message, hash[:8])
+	logger().Info("Committed interactive changes", "message", message, "hash", hash[:8])
 	return hash, nil
 }
 
 // GetCurrentCommitHash returns the current HEAD commit hash
 // Returns empty string if Git is not initialized or an error occurs
-func (s *Service) GetCurrentCommitHash() (string, error) {
+func (s *Service) GetCurrentCommitHash(ctx context.Context) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.GetCurrentCommitHash")
+	defer span.EndErr(&err)
+
 	if !s.gitInited {
 		return "", fmt.Errorf("git not initialized")
 	}
 
-	hash, err := s.executeGitCommand("rev-parse", "HEAD")
+	hash, err := s.executeGitCommand(ctx, "rev-parse", "HEAD")
 	if err != nil {
 		return "", fmt.Errorf("git rev-parse failed: %w", err)
 	}
@@ -367,14 +681,17 @@ func (s *Service) GetCurrentCommitHash() (string, error) {
 // This is used during playback to restore workspace state at a specific point in time
 // If the input looks like a branch name (no special chars), it checks out the branch
 // Otherwise, it treats it as a commit hash
-func (s *Service) CheckoutCommit(target string) error {
+func (s *Service) CheckoutCommit(ctx context.Context, target string) (err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.CheckoutCommit", "target", target)
+	defer span.EndErr(&err)
+
 	// First, discard any local changes
-	if _, err := s.executeGitCommand("reset", "--hard", "HEAD"); err != nil {
+	if _, err := s.executeGitCommand(ctx, "reset", "--hard", "HEAD"); err != nil {
 		return fmt.Errorf("git reset failed: %w", err)
 	}
 
 	// Checkout the target (branch or commit hash)
-	if _, err := s.executeGitCommand("checkout", target); err != nil {
+	if _, err := s.executeGitCommand(ctx, "checkout", target); err != nil {
 		return fmt.Errorf("git checkout %s failed: %w", target, err)
 	}
 
@@ -385,32 +702,35 @@ func (s *Service) CheckoutCommit(target string) error {
 		if len(target) > 8 {
 			displayHash = target[:8]
 		}
-		log.Printf("[FS] ✅ Checked out commit: %s", displayHash)
+		logger().Info("Checked out commit", "hash", displayHash)
 	} else {
-		log.Printf("[FS] ✅ Checked out branch: %s", target)
+		logger().Info("Checked out branch", "branch", target)
 	}
 	return nil
 }
 
 // CreateBranchAndCheckout creates a new branch from a specific commit and checks it out
 // This is used when the user pauses playback to interact with the code
-func (s *Service) CreateBranchAndCheckout(commitHash string, branchName string) error {
+func (s *Service) CreateBranchAndCheckout(ctx context.Context, commitHash string, branchName string) (err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.CreateBranchAndCheckout", "hash", commitHash, "branch", branchName)
+	defer span.EndErr(&err)
+
 	// First, discard any local changes
-	if _, err := s.executeGitCommand("reset", "--hard", "HEAD"); err != nil {
+	if _, err := s.executeGitCommand(ctx, "reset", "--hard", "HEAD"); err != nil {
 		return fmt.Errorf("git reset failed: %w", err)
 	}
 
 	// Checkout the commit first (detached HEAD state)
-	if _, err := s.executeGitCommand("checkout", commitHash); err != nil {
+	if _, err := s.executeGitCommand(ctx, "checkout", commitHash); err != nil {
 		return fmt.Errorf("git checkout %s failed: %w", commitHash, err)
 	}
 
 	// Create and checkout a new branch from this commit
-	if _, err := s.executeGitCommand("checkout", "-b", branchName); err != nil {
+	if _, err := s.executeGitCommand(ctx, "checkout", "-b", branchName); err != nil {
 		return fmt.Errorf("git checkout -b %s failed: %w", branchName, err)
 	}
 
-	log.Printf("[FS] ✅ Created and checked out branch: %s from commit: %s", branchName, commitHash[:8])
+	logger().Info("Created and checked out branch", "branch", branchName, "hash", commitHash[:8])
 	return nil
 }
 
@@ -418,7 +738,10 @@ func (s *Service) CreateBranchAndCheckout(commitHash string, branchName string)
 // If the branch exists, it checks it out and commits changes
 // If the branch doesn't exist, it creates the branch from current HEAD and commits
 // Returns the branch name and commit hash
-func (s *Service) SaveBranch(timestampSeconds int, mode string) (string, string, error) {
+func (s *Service) SaveBranch(ctx context.Context, timestampSeconds int, mode string) (_ string, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.SaveBranch", "timestamp", timestampSeconds)
+	defer span.EndErr(&err)
+
 	if !s.gitInited {
 		return "", "", fmt.Errorf("git not initialized")
 	}
@@ -426,48 +749,48 @@ func (s *Service) SaveBranch(timestampSeconds int, mode string) (string, string,
 	branchName := fmt.Sprintf("interactive-%ds", timestampSeconds)
 
 	// Check if branch exists
-	branches, err := s.executeGitCommand("branch", "--list", branchName)
+	branches, err := s.executeGitCommand(ctx, "branch", "--list", branchName)
 	branchExists := err == nil && strings.Contains(branches, branchName)
 
 	if branchExists {
 		// Branch exists - checkout and commit changes
-		log.Printf("[FS] Branch %s exists, checking out and committing changes", branchName)
+		logger().Info("Branch exists, checking out and committing changes", "branch", branchName)
 
 		// Checkout existing branch
-		if _, err := s.executeGitCommand("checkout", branchName); err != nil {
+		if _, err := s.executeGitCommand(ctx, "checkout", branchName); err != nil {
 			return "", "", fmt.Errorf("git checkout %s failed: %w", branchName, err)
 		}
 	} else {
 		// Branch doesn't exist - create from current HEAD
-		log.Printf("[FS] Branch %s doesn't exist, creating from current HEAD", branchName)
+		logger().Info("Branch doesn't exist, creating from current HEAD", "branch", branchName)
 
 		// Get current commit hash
-		currentCommit, err := s.GetCurrentCommitHash()
+		currentCommit, err := s.GetCurrentCommitHash(ctx)
 		if err != nil {
 			return "", "", fmt.Errorf("failed to get current commit: %w", err)
 		}
 
 		// Create new branch from current HEAD
-		if _, err := s.executeGitCommand("checkout", "-b", branchName, currentCommit); err != nil {
+		if _, err := s.executeGitCommand(ctx, "checkout", "-b", branchName, currentCommit); err != nil {
 			return "", "", fmt.Errorf("git checkout -b %s failed: %w", branchName, err)
 		}
 	}
 
 	// Commit all changes
 	commitMessage := fmt.Sprintf("Interactive changes at %ds", timestampSeconds)
-	commitHash, err := s.CommitChanges(commitMessage, mode)
+	commitHash, err := s.CommitChanges(ctx, commitMessage, mode)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to commit changes: %w", err)
 	}
 
 	// If no changes were committed (empty hash), get current HEAD
 	if commitHash == "" {
-		commitHash, err = s.GetCurrentCommitHash()
+		commitHash, err = s.GetCurrentCommitHash(ctx)
 		if err != nil {
 			return "", "", fmt.Errorf("failed to get commit hash: %w", err)
 		}
 	}
 
-	log.Printf("[FS] ✅ Saved branch: %s (commit: %s)", branchName, commitHash[:8])
+	logger().Info("Saved branch", "branch", branchName, "hash", commitHash[:8])
 	return branchName, commitHash, nil
 }
@@ -0,0 +1,56 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stagingPrefix starts the name of every temporary file or folder staged
+// inside the workspace beside its destination, so that it can be renamed
+// into place in one step. Staged names are kept out of git, the file index,
+// search and the explorer.
+const stagingPrefix = ".room-staging-"
+
+// Staged names left behind by a crash are removed when a walk of the
+// workspace finds them this long after they last changed.
+const staleStagingAge = time.Hour
+
+// stagingPattern is the os.CreateTemp or os.MkdirTemp pattern for staging
+// name.
+func stagingPattern(name string) string {
+	return stagingPrefix + name + "-*"
+}
+
+// IsStagingPath reports whether path, absolute or relative, is or is inside
+// a staged name.
+func IsStagingPath(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if strings.HasPrefix(part, stagingPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitAddArgs stage every change in the workspace except staged names.
+var gitAddArgs = []string{
+	"add", "--", ".",
+	":(exclude,glob)**/" + stagingPrefix + "*",
+	":(exclude,glob)**/" + stagingPrefix + "*/**",
+}
+
+// removeIfStale removes the staged name at full if nothing has changed it
+// for staleStagingAge.
+func removeIfStale(full string, entry os.DirEntry) {
+	info, err := entry.Info()
+	if err != nil || time.Since(info.ModTime()) < staleStagingAge {
+		return
+	}
+	if err := os.RemoveAll(full); err != nil {
+		logger().Warn("Failed to remove stale staging leftover", "path", full, "error", err)
+		return
+	}
+	logger().Info("Removed stale staging leftover", "path", full)
+}
@@ -0,0 +1,361 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"worker/internal/tracing"
+	"worker/pkg/types"
+)
+
+// Transfer limits.
+const (
+	// DefaultMaxInlineSize is the largest file read or written in one message
+	// unless SetMaxInlineSize changes it.
+	DefaultMaxInlineSize = 4 << 20
+	// DefaultMaxUploadSize is the largest file written in chunks unless
+	// SetMaxUploadSize changes it.
+	DefaultMaxUploadSize = 1 << 30
+	// StreamChunkSize is the size of each chunk of a streamed read.
+	StreamChunkSize = 256 << 10
+	// MaxRangeLength bounds a ranged read or a chunk of a chunked write.
+	MaxRangeLength = 4 << 20
+	// An upload with no chunk for this long is discarded.
+	uploadIdleTimeout = 10 * time.Minute
+)
+
+// FileTooLargeError is returned for a file over the inline size limit; such
+// files are read in ranges or streamed, and written in chunks.
+type FileTooLargeError struct {
+	Path  string
+	Size  int64
+	Limit int64
+}
+
+func (e *FileTooLargeError) Error() string {
+	return fmt.Sprintf("%s is %d bytes, over the %d byte limit for a single message; transfer it in chunks", e.Path, e.Size, e.Limit)
+}
+
+// upload is a chunked write in progress, staged outside the workspace until
+// it is complete.
+type upload struct {
+	file       *os.File
+	size       int64
+	hash       hash.Hash
+	lastActive time.Time
+}
+
+// SetMaxInlineSize sets the largest file read or written in one message.
+func (s *Service) SetMaxInlineSize(n int64) {
+	s.transferMu.Lock()
+	defer s.transferMu.Unlock()
+	s.maxInlineSize = n
+}
+
+// SetMaxUploadSize sets the largest file written in chunks. 0 or less
+// removes the limit.
+func (s *Service) SetMaxUploadSize(n int64) {
+	s.transferMu.Lock()
+	defer s.transferMu.Unlock()
+	s.maxUploadSize = n
+}
+
+// SetDataDir sets the worker's own data directory, where chunked writes are
+// staged. Without one they are staged in the system temporary directory.
+func (s *Service) SetDataDir(dir string) {
+	s.transferMu.Lock()
+	defer s.transferMu.Unlock()
+	s.dataDir = dir
+}
+
+// checkInlineSize fails with a FileTooLargeError if size is over the inline
+// limit.
+func (s *Service) checkInlineSize(relativePath string, size int64) error {
+	s.transferMu.Lock()
+	limit := s.maxInlineSize
+	s.transferMu.Unlock()
+	if limit > 0 && size > limit {
+		return &FileTooLargeError{Path: relativePath, Size: size, Limit: limit}
+	}
+	return nil
+}
+
+// ReadRange reads up to length bytes of a file from offset. The content is
+// always base64, since a range of a text file may split a character.
+func (s *Service) ReadRange(ctx context.Context, relativePath string, offset, length int64) (_ types.FileRange, err error) {
+	_, span := tracing.Start(ctx, "filesystem.ReadRange", "path", relativePath, "offset", offset, "length", length)
+	defer span.EndErr(&err)
+
+	if offset < 0 || length <= 0 {
+		return types.FileRange{}, errors.New("range needs a non-negative offset and a positive length")
+	}
+	length = min(length, MaxRangeLength)
+	fullPath, err := s.securePath(relativePath)
+	if err != nil {
+		return types.FileRange{}, err
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return types.FileRange{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return types.FileRange{}, err
+	}
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return types.FileRange{}, err
+	}
+	return types.FileRange{
+		Content:  base64.StdEncoding.EncodeToString(buf[:n]),
+		Encoding: EncodingBase64,
+		Offset:   offset,
+		Length:   int64(n),
+		Size:     info.Size(),
+		EOF:      offset+int64(n) >= info.Size(),
+	}, nil
+}
+
+// StreamFile reads a whole file in chunks of StreamChunkSize, passing each
+// to onChunk, and returns its size and SHA-256 checksum in hex.
+func (s *Service) StreamFile(ctx context.Context, relativePath string, onChunk func(offset int64, data []byte)) (_ int64, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.StreamFile", "path", relativePath)
+	defer span.EndErr(&err)
+
+	fullPath, err := s.securePath(relativePath)
+	if err != nil {
+		return 0, "", err
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	var offset int64
+	buf := make([]byte, StreamChunkSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, "", err
+		}
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			sum.Write(buf[:n])
+			onChunk(offset, buf[:n])
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, "", err
+		}
+	}
+	return offset, hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// WriteChunk adds a chunk to the upload of a file. A chunk at offset 0 starts
+// a new upload, replacing any unfinished one; every other chunk must start
+// where the previous one ended. An upload that grows over the upload size
+// limit is discarded. It returns the bytes received so far.
+func (s *Service) WriteChunk(ctx context.Context, relativePath string, offset int64, data []byte) (_ int64, err error) {
+	_, span := tracing.Start(ctx, "filesystem.WriteChunk", "path", relativePath, "offset", offset, "bytes", len(data))
+	defer span.EndErr(&err)
+
+	if len(data) > MaxRangeLength {
+		return 0, fmt.Errorf("chunk of %d bytes is over the %d byte limit", len(data), MaxRangeLength)
+	}
+	fullPath, err := s.securePath(relativePath)
+	if err != nil {
+		return 0, err
+	}
+
+	s.transferMu.Lock()
+	defer s.transferMu.Unlock()
+	s.expireUploadsLocked()
+
+	up := s.uploads[fullPath]
+	if offset == 0 {
+		if up != nil {
+			discardUpload(up)
+		}
+		dir := os.TempDir()
+		if s.dataDir != "" {
+			dir = filepath.Join(s.dataDir, "uploads")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return 0, err
+			}
+		}
+		f, err := os.CreateTemp(dir, "upload-*")
+		if err != nil {
+			return 0, err
+		}
+		up = &upload{file: f, hash: sha256.New()}
+		s.uploads[fullPath] = up
+	}
+	if up == nil {
+		return 0, fmt.Errorf("no upload in progress for %s, start again from offset 0", relativePath)
+	}
+	if offset != up.size {
+		return up.size, fmt.Errorf("chunk at offset %d does not follow the %d bytes received", offset, up.size)
+	}
+	if s.maxUploadSize > 0 && up.size+int64(len(data)) > s.maxUploadSize {
+		discardUpload(up)
+		delete(s.uploads, fullPath)
+		return 0, fmt.Errorf("upload of %s is over the %d byte limit", relativePath, s.maxUploadSize)
+	}
+	if _, err := up.file.Write(data); err != nil {
+		discardUpload(up)
+		delete(s.uploads, fullPath)
+		return 0, err
+	}
+	up.hash.Write(data)
+	up.size += int64(len(data))
+	up.lastActive = time.Now()
+	s.scheduleExpiryLocked()
+	return up.size, nil
+}
+
+// FinishUpload checks the SHA-256 checksum of an upload, in hex, and moves it
+// into place, keeping the mode of the file it replaces. If expectedVersion is
+// set, the file must still be at that version, as for UpdateFile; on a
+// conflict the upload is discarded.
+func (s *Service) FinishUpload(ctx context.Context, relativePath, checksum, expectedVersion string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.FinishUpload", "path", relativePath)
+	defer span.EndErr(&err)
+
+	fullPath, err := s.securePath(relativePath)
+	if err != nil {
+		return "", err
+	}
+	s.transferMu.Lock()
+	s.expireUploadsLocked()
+	up := s.uploads[fullPath]
+	delete(s.uploads, fullPath)
+	s.transferMu.Unlock()
+	if up == nil {
+		return "", fmt.Errorf("no upload in progress for %s", relativePath)
+	}
+	defer discardUpload(up)
+
+	if got := hex.EncodeToString(up.hash.Sum(nil)); got != checksum {
+		return "", fmt.Errorf("checksum mismatch for %s: received %d bytes with checksum %s", relativePath, up.size, got)
+	}
+	if err := up.file.Close(); err != nil {
+		return "", err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(fullPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+		return "", err
+	}
+	s.writeMu.Lock()
+	err = s.checkVersion(relativePath, fullPath, expectedVersion)
+	if err == nil {
+		err = moveFile(up.file.Name(), fullPath, mode)
+	}
+	s.writeMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	logger().Info("Chunked upload finished", "path", relativePath, "bytes", up.size)
+	return s.commitChanges(ctx, fmt.Sprintf("FS_UPLOAD_FILE: %s", relativePath))
+}
+
+// AbortUpload discards an unfinished upload.
+func (s *Service) AbortUpload(relativePath string) bool {
+	fullPath, err := s.securePath(relativePath)
+	if err != nil {
+		return false
+	}
+	s.transferMu.Lock()
+	defer s.transferMu.Unlock()
+	s.expireUploadsLocked()
+	up := s.uploads[fullPath]
+	if up != nil {
+		discardUpload(up)
+		delete(s.uploads, fullPath)
+	}
+	return up != nil
+}
+
+// expireUploadsLocked discards uploads idle for uploadIdleTimeout. The
+// caller holds transferMu.
+func (s *Service) expireUploadsLocked() {
+	for path, up := range s.uploads {
+		if time.Since(up.lastActive) > uploadIdleTimeout {
+			logger().Info("Discarding abandoned upload", "path", path, "bytes", up.size)
+			discardUpload(up)
+			delete(s.uploads, path)
+		}
+	}
+}
+
+// scheduleExpiryLocked makes sure that a timer expires uploads while any are
+// in progress, so an upload abandoned by the last client still goes. The
+// caller holds transferMu.
+func (s *Service) scheduleExpiryLocked() {
+	if s.expireTimer != nil || len(s.uploads) == 0 {
+		return
+	}
+	s.expireTimer = time.AfterFunc(uploadIdleTimeout, func() {
+		s.transferMu.Lock()
+		defer s.transferMu.Unlock()
+		s.expireTimer = nil
+		s.expireUploadsLocked()
+		s.scheduleExpiryLocked()
+	})
+}
+
+func discardUpload(up *upload) {
+	up.file.Close()
+	os.Remove(up.file.Name())
+}
+
+// moveFile moves src to dst with the given mode. The staging directory may be
+// on another filesystem, in which case src is copied beside dst first so
+// that dst is still replaced in one step.
+func moveFile(src, dst string, mode os.FileMode) error {
+	if err := os.Chmod(src, mode); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), stagingPattern(filepath.Base(dst)))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
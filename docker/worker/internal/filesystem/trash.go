@@ -0,0 +1,310 @@
+package filesystem
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"worker/internal/tracing"
+	"worker/pkg/types"
+)
+
+// TrashLimits bound what the trash keeps. Older or surplus items are deleted
+// for good, oldest first.
+type TrashLimits struct {
+	MaxItems int
+	MaxAge   time.Duration
+	MaxBytes int64 // Of all items together
+	// MaxItemBytes is the largest item trashed; anything larger, such as
+	// node_modules, is deleted for good rather than copied to the trash.
+	MaxItemBytes int64
+}
+
+// DefaultTrashLimits are the limits unless SetTrashLimits changes them.
+var DefaultTrashLimits = TrashLimits{
+	MaxItems:     100,
+	MaxAge:       7 * 24 * time.Hour,
+	MaxBytes:     1 << 30,
+	MaxItemBytes: 256 << 20,
+}
+
+// Deletion describes what became of a deleted file or folder.
+type Deletion struct {
+	TrashID   string // For RestoreResource, empty if it was not trashed
+	Permanent bool   // Over MaxItemBytes, so deleted for good
+}
+
+// Each trashed item is a folder holding the item itself and its metadata.
+const (
+	trashItemName = "item"
+	trashMetaName = "meta.json"
+)
+
+// SetTrashLimits sets the trash limits. Zero fields leave that limit
+// unchanged.
+func (s *Service) SetTrashLimits(limits TrashLimits) {
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+	if limits.MaxItems > 0 {
+		s.trashLimits.MaxItems = limits.MaxItems
+	}
+	if limits.MaxAge > 0 {
+		s.trashLimits.MaxAge = limits.MaxAge
+	}
+	if limits.MaxBytes > 0 {
+		s.trashLimits.MaxBytes = limits.MaxBytes
+	}
+	if limits.MaxItemBytes > 0 {
+		s.trashLimits.MaxItemBytes = limits.MaxItemBytes
+	}
+}
+
+// trashDir is where deleted items are kept: in the data directory, outside
+// the workspace, so the file tree and git never see them.
+func (s *Service) trashDir() string {
+	s.transferMu.Lock()
+	defer s.transferMu.Unlock()
+	if s.dataDir == "" {
+		return filepath.Join(os.TempDir(), "worker-trash")
+	}
+	return filepath.Join(s.dataDir, "trash")
+}
+
+// moveToTrash moves the file or folder at fullPath into the trash, or
+// deletes it for good if it is over MaxItemBytes. When the trash is on
+// another filesystem the move is a copy, which that limit keeps short.
+func (s *Service) moveToTrash(relativePath, fullPath string) (Deletion, error) {
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return Deletion{}, err
+	}
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+
+	size, err := treeSize(fullPath, s.trashLimits.MaxItemBytes)
+	if err != nil {
+		return Deletion{}, err
+	}
+	if size > s.trashLimits.MaxItemBytes {
+		if err := os.RemoveAll(fullPath); err != nil {
+			return Deletion{}, err
+		}
+		logger().Info("Deleted for good, too large for the trash", "path", relativePath, "limit", s.trashLimits.MaxItemBytes)
+		return Deletion{Permanent: true}, nil
+	}
+
+	dir := s.trashDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Deletion{}, err
+	}
+	// IDs start with the time of deletion so they sort oldest first.
+	itemDir, err := os.MkdirTemp(dir, strconv.FormatInt(time.Now().UnixMilli(), 10)+"-*")
+	if err != nil {
+		return Deletion{}, err
+	}
+	entry := types.TrashEntry{
+		ID:        filepath.Base(itemDir),
+		Path:      filepath.ToSlash(filepath.Join("/workspace", strings.TrimPrefix(fullPath, s.baseDir))),
+		DeletedMs: time.Now().UnixMilli(),
+		IsDir:     info.IsDir(),
+		Size:      size,
+	}
+	meta, _ := json.Marshal(entry)
+	if err := os.WriteFile(filepath.Join(itemDir, trashMetaName), meta, 0600); err != nil {
+		os.RemoveAll(itemDir)
+		return Deletion{}, err
+	}
+	if err := moveTree(fullPath, filepath.Join(itemDir, trashItemName)); err != nil {
+		os.RemoveAll(itemDir)
+		return Deletion{}, err
+	}
+	logger().Info("Moved to trash", "path", relativePath, "id", entry.ID, "bytes", size)
+	s.pruneTrashLocked()
+	return Deletion{TrashID: entry.ID}, nil
+}
+
+// treeSize adds up the sizes of the files at or below path, stopping early
+// once they are over limit.
+func treeSize(path string, limit int64) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		if size > limit {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return size, err
+}
+
+// ListTrash returns the items in the trash, most recently deleted first.
+func (s *Service) ListTrash(ctx context.Context) (_ []types.TrashEntry, err error) {
+	_, span := tracing.Start(ctx, "filesystem.ListTrash")
+	defer span.EndErr(&err)
+
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+	s.pruneTrashLocked()
+	entries := s.trashEntriesLocked()
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// RestoreResource moves an item out of the trash to where it was deleted
+// from, creating missing parent folders. If that path has been taken since,
+// it is restored beside it under a "copy" name. It returns the path it was
+// restored to and the commit hash.
+func (s *Service) RestoreResource(ctx context.Context, id string) (_, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "filesystem.RestoreResource", "id", id)
+	defer span.EndErr(&err)
+
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", "", fmt.Errorf("invalid trash id %q", id)
+	}
+	s.trashMu.Lock()
+	itemDir := filepath.Join(s.trashDir(), id)
+	entry, err := readTrashEntry(itemDir)
+	var fullPath string
+	if err == nil {
+		fullPath, err = s.restorePath(entry.Path)
+	}
+	if err == nil {
+		err = moveTree(filepath.Join(itemDir, trashItemName), fullPath)
+	}
+	if err == nil {
+		os.RemoveAll(itemDir)
+	}
+	s.trashMu.Unlock()
+	if errors.Is(err, os.ErrNotExist) && entry.ID == "" {
+		return "", "", fmt.Errorf("%s is not in the trash", id)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	rel, _ := filepath.Rel(s.baseDir, fullPath)
+	restored := filepath.ToSlash(filepath.Join("/workspace", rel))
+	logger().Info("Restored from trash", "path", restored, "id", id)
+	hash, err := s.commitChanges(ctx, fmt.Sprintf("FS_RESTORE_RESOURCE: %s", restored))
+	return restored, hash, err
+}
+
+// restorePath returns a free path inside the workspace to restore relativePath
+// to, with its parent folders in place.
+func (s *Service) restorePath(relativePath string) (string, error) {
+	fullPath, err := s.securePath(relativePath)
+	if err != nil {
+		return "", err
+	}
+	if fullPath == s.baseDir {
+		return "", errors.New("cannot restore over the workspace root")
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+		return "", err
+	}
+	return freeCopyName(fullPath)
+}
+
+// pruneTrashLocked deletes items past the age limit, and the oldest items
+// while the trash is over its count or byte limit. The caller holds trashMu.
+func (s *Service) pruneTrashLocked() {
+	entries := s.trashEntriesLocked()
+	cutoff := time.Now().Add(-s.trashLimits.MaxAge).UnixMilli()
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	for i, entry := range entries {
+		if entry.DeletedMs >= cutoff && len(entries)-i <= s.trashLimits.MaxItems && total <= s.trashLimits.MaxBytes {
+			break
+		}
+		total -= entry.Size
+		if err := os.RemoveAll(filepath.Join(s.trashDir(), entry.ID)); err != nil {
+			logger().Warn("Failed to empty trash item", "id", entry.ID, "error", err)
+			continue
+		}
+		logger().Debug("Emptied trash item", "id", entry.ID, "path", entry.Path)
+	}
+}
+
+// trashEntriesLocked reads the items in the trash, oldest first. Leftovers
+// of a failed move are removed. The caller holds trashMu.
+func (s *Service) trashEntriesLocked() []types.TrashEntry {
+	dir := s.trashDir()
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var entries []types.TrashEntry
+	for _, d := range dirEntries {
+		itemDir := filepath.Join(dir, d.Name())
+		entry, err := readTrashEntry(itemDir)
+		if err != nil {
+			logger().Warn("Removing unreadable trash item", "id", d.Name(), "error", err)
+			os.RemoveAll(itemDir)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b types.TrashEntry) int {
+		return cmp.Or(cmp.Compare(a.DeletedMs, b.DeletedMs), strings.Compare(a.ID, b.ID))
+	})
+	return entries
+}
+
+func readTrashEntry(itemDir string) (types.TrashEntry, error) {
+	meta, err := os.ReadFile(filepath.Join(itemDir, trashMetaName))
+	if err != nil {
+		return types.TrashEntry{}, err
+	}
+	var entry types.TrashEntry
+	if err := json.Unmarshal(meta, &entry); err != nil {
+		return types.TrashEntry{}, err
+	}
+	if _, err := os.Lstat(filepath.Join(itemDir, trashItemName)); err != nil {
+		return types.TrashEntry{}, err
+	}
+	entry.ID = filepath.Base(itemDir)
+	return entry, nil
+}
+
+// moveTree moves a file or folder, copying it when src and dst are on
+// different filesystems, as the data directory may be.
+func moveTree(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	// Copy beside dst first so a failed copy leaves neither half-moved.
+	parent, name := filepath.Split(dst)
+	tmp, err := os.MkdirTemp(parent, stagingPattern(name))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	staged := filepath.Join(tmp, name)
+	if err := copyTree(src, staged); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
@@ -0,0 +1,56 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// GitCheck verifies that the git binary is installed and runnable.
+func GitCheck() Check {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		path, err := exec.LookPath("git")
+		if err != nil {
+			return nil, fmt.Errorf("git not found in PATH: %w", err)
+		}
+		out, err := exec.CommandContext(ctx, path, "--version").Output()
+		if err != nil {
+			return map[string]interface{}{"path": path}, fmt.Errorf("git --version failed: %w", err)
+		}
+		return map[string]interface{}{
+			"path":    path,
+			"version": strings.TrimSpace(string(out)),
+		}, nil
+	}
+}
+
+// DiskCheck verifies that dir is writable and has at least minFreeBytes available.
+func DiskCheck(dir string, minFreeBytes uint64) Check {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(dir, &st); err != nil {
+			return nil, fmt.Errorf("statfs %s failed: %w", dir, err)
+		}
+		free := st.Bavail * uint64(st.Bsize)
+		total := st.Blocks * uint64(st.Bsize)
+		details := map[string]interface{}{
+			"path":         dir,
+			"freeBytes":    free,
+			"totalBytes":   total,
+			"minFreeBytes": minFreeBytes,
+		}
+		if free < minFreeBytes {
+			return details, fmt.Errorf("only %d bytes free in %s (minimum %d)", free, dir, minFreeBytes)
+		}
+
+		// Probe with access(2) rather than a temp file, which would show up in
+		// the file watcher and could be swept into a recording commit.
+		const wOK = 0x2
+		if err := syscall.Access(dir, wOK); err != nil {
+			return details, fmt.Errorf("%s is not writable: %w", dir, err)
+		}
+		return details, nil
+	}
+}
@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Attribute keys shared by the bridge and the worker so that log lines from
+// both services can be joined on the same fields.
+const (
+	KeyService     = "service"
+	KeyComponent   = "component"
+	KeyWorkspaceID = "workspaceId"
+	KeyClientID    = "clientId"
+	KeyAckID       = "ackId"
+	KeyTraceID     = "traceId"
+	KeyEvent       = "event"
+)
+
+// noisyEvents are logged only once every sampleRate occurrences below WARN,
+// so a busy shell does not flood the logs.
+var noisyEvents = map[string]bool{
+	"terminal-data":  true,
+	"terminal-input": true,
+}
+
+// Init installs the default slog logger for the process.
+//
+// LOG_LEVEL selects the minimum level (debug, info, warn, error; default info),
+// LOG_FORMAT selects "json" (default) or "text", and LOG_SAMPLE_RATE sets how
+// many noisy events are seen per logged line (default 100, 1 disables sampling).
+func Init(service string, attrs ...any) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: parseLevel(os.Getenv("LOG_LEVEL"))}
+
+	var handler slog.Handler
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "text") {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+
+	rate := uint64(100)
+	if v, err := strconv.ParseUint(os.Getenv("LOG_SAMPLE_RATE"), 10, 64); err == nil && v > 0 {
+		rate = v
+	}
+	handler = newSamplingHandler(handler, rate)
+
+	logger := slog.New(handler).With(KeyService, service).With(attrs...)
+	slog.SetDefault(logger)
+	return logger
+}
+
+func parseLevel(s string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// ============================================================================
+// SAMPLING
+// ============================================================================
+
+// samplingHandler drops all but one in `rate` records whose "event" attribute
+// names a noisy event. Warnings and errors are never sampled.
+type samplingHandler struct {
+	next     slog.Handler
+	rate     uint64
+	counters *sync.Map // event name -> *atomic.Uint64
+	event    string    // event attribute bound through WithAttrs, if any
+}
+
+func newSamplingHandler(next slog.Handler, rate uint64) *samplingHandler {
+	return &samplingHandler{next: next, rate: rate, counters: &sync.Map{}}
+}
+
+func (h *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if h.rate > 1 && r.Level < slog.LevelWarn {
+		event := h.event
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == KeyEvent {
+				event = a.Value.String()
+				return false
+			}
+			return true
+		})
+		if noisyEvents[event] && !h.sample(event) {
+			return nil
+		}
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *samplingHandler) sample(event string) bool {
+	v, _ := h.counters.LoadOrStore(event, new(atomic.Uint64))
+	return (v.(*atomic.Uint64).Add(1)-1)%h.rate == 0
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithAttrs(attrs)
+	for _, a := range attrs {
+		if a.Key == KeyEvent {
+			clone.event = a.Value.String()
+		}
+	}
+	return &clone
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithGroup(name)
+	return &clone
+}
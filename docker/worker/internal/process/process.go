@@ -0,0 +1,146 @@
+// Package process manages the worker's descendant processes: signalling whole
+// sessions and reaping orphans that would otherwise linger as zombies.
+package process
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Stat is the subset of /proc/<pid>/stat the worker cares about.
+type Stat struct {
+	PID     int
+	State   byte // R, S, D, Z, ...
+	PPID    int
+	PGID    int
+	Session int
+}
+
+// ReadStat parses /proc/<pid>/stat.
+func ReadStat(pid int) (Stat, error) {
+	raw, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return Stat{}, err
+	}
+	// The command name is in parentheses and may itself contain spaces or
+	// parentheses, so parse from the last ')'.
+	line := string(raw)
+	end := strings.LastIndexByte(line, ')')
+	if end < 0 {
+		return Stat{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	fields := strings.Fields(line[end+1:])
+	if len(fields) < 4 || len(fields[0]) != 1 {
+		return Stat{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	st := Stat{PID: pid, State: fields[0][0]}
+	if st.PPID, err = strconv.Atoi(fields[1]); err != nil {
+		return Stat{}, err
+	}
+	if st.PGID, err = strconv.Atoi(fields[2]); err != nil {
+		return Stat{}, err
+	}
+	if st.Session, err = strconv.Atoi(fields[3]); err != nil {
+		return Stat{}, err
+	}
+	return st, nil
+}
+
+// List returns the stat of every process visible in /proc. Processes that
+// exit during the scan are skipped.
+func List() ([]Stat, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var stats []Stat
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if st, err := ReadStat(pid); err == nil {
+			stats = append(stats, st)
+		}
+	}
+	return stats, nil
+}
+
+// SignalSession sends sig to every live process in session sid and returns
+// how many were signalled. This reaches jobs the shell moved into their own
+// process groups, which signalling the shell's group alone would miss.
+func SignalSession(sid int, sig syscall.Signal) (int, error) {
+	stats, err := List()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, st := range stats {
+		if st.Session != sid || st.State == 'Z' {
+			continue
+		}
+		if err := syscall.Kill(st.PID, sig); err == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// WaitSessionEmpty polls until no live process remains in session sid or
+// timeout elapses, and reports whether the session emptied.
+func WaitSessionEmpty(sid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		stats, err := List()
+		if err != nil {
+			return false
+		}
+		alive := false
+		for _, st := range stats {
+			if st.Session == sid && st.State != 'Z' {
+				alive = true
+				break
+			}
+		}
+		if !alive {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// signals users may send to a terminal, by name.
+var signals = map[string]syscall.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGHUP":  syscall.SIGHUP,
+}
+
+// ParseSignal maps a name such as "SIGINT" or "INT" to an allowed signal.
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := signals[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported signal %q", name)
+	}
+	return sig, nil
+}
+
+// SignalName returns the conventional name of sig, e.g. "SIGTERM".
+func SignalName(sig syscall.Signal) string {
+	return unix.SignalName(sig)
+}
@@ -0,0 +1,56 @@
+package process
+
+import (
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+	"worker/internal/logging"
+
+	"golang.org/x/sys/unix"
+)
+
+func logger() *slog.Logger {
+	return slog.With(logging.KeyComponent, "process")
+}
+
+// StartReaper makes the worker a child subreaper, so processes orphaned by
+// terminal shells are re-parented to it rather than to init, and reaps those
+// orphans once they exit.
+//
+// The worker also has children it waits for itself (shells, git), and
+// reaping one of those would make its exec.Cmd.Wait fail. A zombie child is
+// therefore only reaped once it has stayed a zombie for a full interval:
+// exec.Cmd owners are already blocked in Wait and collect their children at
+// once.
+func StartReaper(interval time.Duration) {
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		logger().Warn("Failed to become child subreaper, orphans will be reaped by init", "error", err)
+	}
+	go func() {
+		self := os.Getpid()
+		seen := map[int]bool{}
+		for range time.Tick(interval) {
+			stats, err := List()
+			if err != nil {
+				logger().Warn("Failed to scan processes", "error", err)
+				continue
+			}
+			zombies := map[int]bool{}
+			for _, st := range stats {
+				if st.PPID != self || st.State != 'Z' {
+					continue
+				}
+				if !seen[st.PID] {
+					zombies[st.PID] = true
+					continue
+				}
+				var ws syscall.WaitStatus
+				if pid, err := syscall.Wait4(st.PID, &ws, syscall.WNOHANG, nil); err == nil && pid == st.PID {
+					logger().Debug("Reaped orphaned process", "pid", pid, "exitCode", ws.ExitStatus())
+				}
+			}
+			seen = zombies
+		}
+	}()
+}
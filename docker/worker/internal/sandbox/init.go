@@ -0,0 +1,202 @@
+package sandbox
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+type helperOptions struct {
+	workspace string
+	cwd       string
+	noNetwork bool
+	probe     bool
+	argv      []string
+}
+
+// Main is the entry point of the init helper. It sets up the sandbox and
+// execs the shell; it only returns by exiting.
+func Main() {
+	// Capability sets are per thread: drop them on the thread that execs.
+	runtime.LockOSThread()
+
+	opts, err := parseHelperArgs(os.Args[1:])
+	if err == nil {
+		err = setup(opts)
+	}
+	if err == nil && opts.probe {
+		os.Exit(0)
+	}
+	if err == nil {
+		err = syscall.Exec(opts.argv[0], opts.argv, os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+	os.Exit(1)
+}
+
+func parseHelperArgs(args []string) (helperOptions, error) {
+	var opts helperOptions
+	fs := flag.NewFlagSet(helperName, flag.ContinueOnError)
+	fs.StringVar(&opts.workspace, "workspace", "", "workspace to bind read-write")
+	fs.StringVar(&opts.cwd, "cwd", "", "working directory")
+	fs.BoolVar(&opts.noNetwork, "no-network", false, "bring up loopback only")
+	fs.BoolVar(&opts.probe, "probe", false, "exit after setup")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	opts.argv = fs.Args()
+	if !filepath.IsAbs(opts.workspace) {
+		return opts, fmt.Errorf("workspace must be an absolute path, got %q", opts.workspace)
+	}
+	if len(opts.argv) == 0 && !opts.probe {
+		return opts, errors.New("no command given")
+	}
+	return opts, nil
+}
+
+func setup(opts helperOptions) error {
+	// Keep every mount below private to this namespace.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+	if err := setReadOnly("/", true); err != nil {
+		return fmt.Errorf("failed to make root read-only: %w", err)
+	}
+
+	// A tmpfs over a directory containing the workspace would hide it.
+	if !within(opts.workspace, "/tmp") {
+		if err := unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("failed to mount /tmp: %w", err)
+		}
+	}
+	// Tools expect a writable home for caches and history.
+	if home := os.Getenv("HOME"); home != "" && filepath.IsAbs(home) && !within(opts.workspace, home) && !within(home, opts.workspace) {
+		if info, err := os.Stat(home); err == nil && info.IsDir() {
+			if err := unix.Mount("tmpfs", home, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=0755"); err != nil {
+				return fmt.Errorf("failed to mount %s: %w", home, err)
+			}
+		}
+	}
+
+	if err := bind(opts.workspace, false); err != nil {
+		return fmt.Errorf("failed to mount workspace: %w", err)
+	}
+	// History is recorded by the worker; learners may read it but not rewrite it.
+	if gitDir := filepath.Join(opts.workspace, ".git"); exists(gitDir) {
+		if err := bind(gitDir, true); err != nil {
+			return fmt.Errorf("failed to protect .git: %w", err)
+		}
+	}
+
+	// A /proc for the new pid namespace, hiding the worker's processes.
+	if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount /proc: %w", err)
+	}
+	if err := unix.Sethostname([]byte(Hostname)); err != nil {
+		return fmt.Errorf("failed to set hostname: %w", err)
+	}
+	if opts.noNetwork {
+		if err := loopbackUp(); err != nil {
+			return fmt.Errorf("failed to bring up loopback: %w", err)
+		}
+	}
+
+	// The old working directory is on the read-only mount under the bind.
+	if err := os.Chdir(opts.cwd); err != nil {
+		return err
+	}
+	return dropPrivileges()
+}
+
+// bind mounts path over itself with the given access.
+func bind(path string, readOnly bool) error {
+	if err := unix.Mount(path, path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return err
+	}
+	return setReadOnly(path, readOnly)
+}
+
+// setReadOnly changes the access of the mount tree at path.
+func setReadOnly(path string, readOnly bool) error {
+	attr := &unix.MountAttr{}
+	if readOnly {
+		attr.Attr_set = unix.MOUNT_ATTR_RDONLY
+	} else {
+		attr.Attr_clr = unix.MOUNT_ATTR_RDONLY
+	}
+	err := unix.MountSetattr(unix.AT_FDCWD, path, unix.AT_RECURSIVE, attr)
+	if errors.Is(err, unix.ENOSYS) {
+		return fmt.Errorf("mount_setattr is unavailable, the sandbox needs Linux 5.12 or later: %w", err)
+	}
+	return err
+}
+
+// loopbackUp enables lo, which starts down in a new network namespace, so
+// learners can still reach their own dev servers.
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
+
+// dropPrivileges leaves the shell without capabilities in the namespace, even
+// when it runs as the namespace's root, and stops setuid or file-capability
+// binaries from regaining any.
+func dropPrivileges() error {
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to clear ambient capabilities: %w", err)
+	}
+	for c := 0; ; c++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0)
+		if errors.Is(err, unix.EINVAL) {
+			break // Past the last capability the kernel knows.
+		}
+		if err != nil {
+			return fmt.Errorf("failed to drop capability %d: %w", c, err)
+		}
+	}
+	// Root gets back its inheritable set on exec, whatever the bounding set.
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("failed to read capabilities: %w", err)
+	}
+	data[0].Inheritable, data[1].Inheritable = 0, 0
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("failed to clear inheritable capabilities: %w", err)
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	return nil
+}
+
+// within reports whether path is dir or inside it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
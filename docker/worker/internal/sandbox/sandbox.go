@@ -0,0 +1,100 @@
+// Package sandbox runs learner commands in Linux namespaces, so they cannot
+// see or signal the worker, read its environment, or write outside the
+// workspace.
+//
+// The worker re-executes itself as a small init helper in new user, mount,
+// pid, UTS and IPC namespaces (plus a network namespace when networking is
+// off). The helper makes the root filesystem read-only, bind-mounts the
+// workspace read-write with .git read-only, mounts a private /proc and /tmp,
+// drops every capability and then execs the shell, which becomes PID 1 of
+// the terminal's pid namespace. Only unprivileged user namespaces are
+// required, so it works on a plain Linux box without root.
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// helperName is argv[0] of the re-executed worker binary.
+const helperName = "worker-sandbox-init"
+
+// Hostname seen inside the sandbox.
+const Hostname = "workspace"
+
+// Config describes the sandbox terminals run in.
+type Config struct {
+	// Workspace is the absolute path of the workspace, the only writable
+	// directory besides a private /tmp and $HOME.
+	Workspace string
+	// Network keeps the worker's network. Without it only loopback exists.
+	Network bool
+}
+
+// IsHelper reports whether this process is the sandbox init helper rather
+// than the worker. main must check it before doing anything else.
+func IsHelper() bool {
+	return len(os.Args) > 0 && os.Args[0] == helperName
+}
+
+// Command returns a command that runs argv in the sandbox, starting in dir.
+// argv[0] must be an absolute path. The caller sets Env and may add to
+// SysProcAttr.
+func (c *Config) Command(dir string, argv ...string) *exec.Cmd {
+	args := []string{"--workspace", c.Workspace, "--cwd", dir}
+	if !c.Network {
+		args = append(args, "--no-network")
+	}
+	args = append(args, "--")
+	args = append(args, argv...)
+
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Args[0] = helperName
+	cmd.Dir = dir
+	cmd.SysProcAttr = c.sysProcAttr()
+	return cmd
+}
+
+// Probe starts the helper once without a shell, to find out at startup
+// whether the host allows the sandbox.
+func (c *Config) Probe() error {
+	cmd := exec.Command("/proc/self/exe", "--workspace", c.Workspace, "--cwd", c.Workspace, "--probe")
+	if !c.Network {
+		cmd.Args = append(cmd.Args, "--no-network")
+	}
+	cmd.Args[0] = helperName
+	cmd.Env = []string{}
+	cmd.SysProcAttr = c.sysProcAttr()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *Config) sysProcAttr() *syscall.SysProcAttr {
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+		syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC
+	if !c.Network {
+		flags |= syscall.CLONE_NEWNET
+	}
+	// Map only the worker's own IDs, so files created in the workspace keep
+	// their owner and nothing else on the host is reachable by ID.
+	uid, gid := os.Getuid(), os.Getgid()
+	return &syscall.SysProcAttr{
+		Cloneflags:  uintptr(flags),
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}},
+		// A non-root worker loses its namespace capabilities when it execs
+		// the helper; keep the ones the helper needs to set up. The helper
+		// drops them all before starting the shell.
+		AmbientCaps: []uintptr{unix.CAP_SYS_ADMIN, unix.CAP_SETPCAP, unix.CAP_NET_ADMIN},
+	}
+}
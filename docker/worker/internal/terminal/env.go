@@ -0,0 +1,85 @@
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultShell is started when neither the request nor config.toml names one.
+const DefaultShell = "bash"
+
+// DefaultAllowedShells are the shells a terminal may run unless overridden
+// with WORKER_ALLOWED_SHELLS.
+var DefaultAllowedShells = []string{"bash", "sh", "zsh", "fish"}
+
+// passthroughEnv lists the worker's variables that terminals inherit. Anything
+// else in the worker's environment (credentials, tokens, service settings)
+// stays out of learner shells.
+var passthroughEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "LANG", "LC_ALL", "TZ"}
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// resolveShell maps an allowlisted shell name to its path in PATH. Paths are
+// rejected so a workspace file cannot pose as a shell.
+func resolveShell(name string, allowed []string) (string, error) {
+	if name == "" {
+		name = DefaultShell
+	}
+	if !slices.Contains(allowed, name) {
+		return "", fmt.Errorf("shell %q is not allowed (allowed: %s)", name, strings.Join(allowed, ", "))
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("shell %q is not installed: %w", name, err)
+	}
+	return path, nil
+}
+
+// buildEnv returns a sanitized base environment for shellPath overlaid with
+// extra, which must only contain valid variable names. An empty shellPath
+// leaves SHELL unset.
+func buildEnv(shellPath string, extra map[string]string) ([]string, error) {
+	env := map[string]string{
+		"TERM":      "xterm-256color",
+		"COLORTERM": "truecolor",
+	}
+	if shellPath != "" {
+		env["SHELL"] = shellPath
+	}
+	for _, key := range passthroughEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			env[key] = value
+		}
+	}
+	if _, ok := env["PATH"]; !ok {
+		env["PATH"] = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	}
+	if _, ok := env["LANG"]; !ok {
+		env["LANG"] = "C.UTF-8"
+	}
+
+	for key, value := range extra {
+		if !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid environment variable name %q", key)
+		}
+		if strings.ContainsRune(value, 0) {
+			return nil, fmt.Errorf("invalid value for environment variable %s", key)
+		}
+		env[key] = value
+	}
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	out := make([]string, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, key+"="+env[key])
+	}
+	return out, nil
+}
@@ -0,0 +1,232 @@
+package terminal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Output streams of a command run with Exec.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// Timeouts for Exec. The bridge waits MaxExecTimeout plus a margin for the
+// acknowledgement, so raising the maximum needs a matching bridge change.
+const (
+	DefaultExecTimeout = 60 * time.Second
+	MaxExecTimeout     = 10 * time.Minute
+)
+
+// OutputFunc receives a chunk of a command's output.
+type OutputFunc func(stream string, data []byte)
+
+// ExecOptions describe a non-interactive command. Zero values select the
+// defaults.
+type ExecOptions struct {
+	Command string // Program looked up in PATH, or a path relative to Cwd
+	Args    []string
+	Cwd     string            // Absolute working directory, already confined to the workspace
+	Env     map[string]string // Added to the sanitized base environment
+	Timeout time.Duration     // Capped at MaxExecTimeout
+}
+
+// ExecResult describes how a command ended.
+type ExecResult struct {
+	ExitStatus
+	Duration time.Duration
+	// TimedOut is set when the command was killed for exceeding its timeout.
+	TimedOut bool
+}
+
+// Exec runs a command to completion without a terminal, passing stdout and
+// stderr to onOutput as they arrive. The command gets the same sanitized
+// environment, cgroup and sandbox as a terminal. It fails only if the
+// command cannot be started; a non-zero exit is reported in the result.
+func (s *Service) Exec(ctx context.Context, opts ExecOptions, onOutput OutputFunc) (ExecResult, error) {
+	dir := opts.Cwd
+	if dir == "" {
+		dir = s.defaultDir()
+	}
+	path, err := resolveCommand(opts.Command, dir)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	env, err := buildEnv("", opts.Env)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	timeout = min(timeout, MaxExecTimeout)
+	return s.manager.Exec(ctx, Command{Path: path, Dir: dir, Env: env}, opts.Args, timeout, onOutput)
+}
+
+// resolveCommand finds the program to run: a bare name is looked up in PATH,
+// anything with a slash is taken relative to dir.
+func resolveCommand(name, dir string) (string, error) {
+	if name == "" {
+		return "", errors.New("command is required")
+	}
+	if !strings.Contains(name, "/") {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return "", fmt.Errorf("command %q not found: %w", name, err)
+		}
+		return path, nil
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", fmt.Errorf("command %q not found: %w", name, err)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return "", fmt.Errorf("command %q is not executable", name)
+	}
+	return name, nil
+}
+
+// Exec runs c.Path with args and waits for it, killing it and everything it
+// started once timeout elapses or ctx is cancelled.
+func (m *Manager) Exec(ctx context.Context, c Command, args []string, timeout time.Duration, onOutput OutputFunc) (ExecResult, error) {
+	m.mu.Lock()
+	var cmd *exec.Cmd
+	if m.sandbox != nil {
+		cmd = m.sandbox.Command(c.Dir, append([]string{c.Path}, args...)...)
+	} else {
+		cmd = exec.Command(c.Path, args...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	group, groupDir := m.newGroup()
+	m.mu.Unlock()
+
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	// Its own process group, so a timeout takes down its children too.
+	cmd.SysProcAttr.Setpgid = true
+	if groupDir != nil {
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(groupDir.Fd())
+	}
+
+	// Plain pipes rather than StdoutPipe: a background child may keep them
+	// open after the command exits, and Wait must not block on it.
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return ExecResult{}, err
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		stdoutR.Close()
+		stdoutW.Close()
+		return ExecResult{}, err
+	}
+	cmd.Stdout, cmd.Stderr = stdoutW, stderrW
+
+	start := time.Now()
+	err = cmd.Start()
+	stdoutW.Close()
+	stderrW.Close()
+	if groupDir != nil {
+		groupDir.Close()
+	}
+	if err != nil {
+		stdoutR.Close()
+		stderrR.Close()
+		if group != nil {
+			group.Remove()
+		}
+		return ExecResult{}, fmt.Errorf("failed to start %s: %w", c.Path, err)
+	}
+	pid := cmd.Process.Pid
+	logger().Info("Exec started", "pid", pid, "command", c.Path, "args", args, "cwd", c.Dir, "timeout", timeout)
+
+	var readers sync.WaitGroup
+	for stream, r := range map[string]*os.File{StreamStdout: stdoutR, StreamStderr: stderrR} {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			defer r.Close()
+			pump(r, func(data []byte) { onOutput(stream, data) })
+		}()
+	}
+
+	killAll := func() {
+		syscall.Kill(-pid, syscall.SIGKILL)
+		if group != nil {
+			group.Kill()
+		}
+	}
+	waitDone := make(chan error, 1)
+	go func() { waitDone <- cmd.Wait() }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var waitErr error
+	timedOut := false
+	select {
+	case waitErr = <-waitDone:
+	case <-timer.C:
+		timedOut = true
+		killAll()
+		waitErr = <-waitDone
+	case <-ctx.Done():
+		killAll()
+		waitErr = <-waitDone
+	}
+	duration := time.Since(start)
+
+	// Leftover background processes would hold the pipes open.
+	killAll()
+	readersDone := make(chan struct{})
+	go func() {
+		readers.Wait()
+		close(readersDone)
+	}()
+	select {
+	case <-readersDone:
+	case <-time.After(closeGracePeriod):
+		logger().Warn("Exec output still open after it exited", "pid", pid)
+		stdoutR.Close()
+		stderrR.Close()
+		<-readersDone
+	}
+	if group != nil {
+		if err := group.Remove(); err != nil {
+			logger().Warn("Failed to remove exec cgroup", "pid", pid, "error", err)
+		}
+	}
+
+	result := ExecResult{ExitStatus: waitStatus(waitErr), Duration: duration, TimedOut: timedOut}
+	logger().Info("Exec finished", "pid", pid, "command", c.Path, "code", result.Code, "signal", result.Signal, "duration", duration, "timedOut", timedOut)
+	return result, nil
+}
+
+// pump copies r to onData in chunks until EOF.
+func pump(r io.Reader, onData func(data []byte)) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			onData(data)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
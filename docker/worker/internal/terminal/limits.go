@@ -0,0 +1,82 @@
+package terminal
+
+import (
+	"os"
+	"time"
+	"worker/internal/cgroup"
+
+	"github.com/google/uuid"
+)
+
+// Resource limit events reported through the Manager's event callback.
+const (
+	EventOOM           = "terminal-oom"
+	EventLimitExceeded = "limit-exceeded"
+)
+
+const limitPollInterval = time.Second
+
+// newGroup creates the cgroup for a new terminal and opens it for
+// SysProcAttr.CgroupFD. Without cgroups, or if creation fails, the terminal
+// runs unconfined and both results are nil.
+func (m *Manager) newGroup() (*cgroup.Group, *os.File) {
+	if m.cgroups == nil {
+		return nil, nil
+	}
+	// Terminal IDs come from the frontend, so they do not name the cgroup.
+	group, err := m.cgroups.NewGroup("terminal-" + uuid.New().String())
+	if err != nil {
+		logger().Warn("Failed to create terminal cgroup, running without limits", "error", err)
+		return nil, nil
+	}
+	dir, err := group.Open()
+	if err != nil {
+		logger().Warn("Failed to open terminal cgroup, running without limits", "error", err)
+		group.Remove()
+		return nil, nil
+	}
+	return group, dir
+}
+
+// watchLimits reports limit events for a terminal's cgroup until the shell
+// exits, then makes a final check and closes done.
+func (m *Manager) watchLimits(id string, s *session, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(limitPollInterval)
+	defer ticker.Stop()
+
+	var last cgroup.Events
+	for {
+		select {
+		case <-s.exited:
+			m.reportLimits(id, s.group, last)
+			return
+		case <-ticker.C:
+			last = m.reportLimits(id, s.group, last)
+		}
+	}
+}
+
+// reportLimits emits events for counters that grew since last.
+func (m *Manager) reportLimits(id string, group *cgroup.Group, last cgroup.Events) cgroup.Events {
+	ev := group.Events()
+	limits := group.Limits()
+	if n := ev.OOMKills - last.OOMKills; n > 0 {
+		logger().Warn("Terminal process killed for exceeding memory limit", "terminalId", id, "kills", n, "memoryMax", limits.MemoryMax)
+		m.onEvent(EventOOM, map[string]interface{}{
+			"id":        id,
+			"kills":     n,
+			"memoryMax": limits.MemoryMax,
+		})
+	}
+	if n := ev.PidsMax - last.PidsMax; n > 0 {
+		logger().Warn("Terminal hit process limit", "terminalId", id, "refusedForks", n, "pidsMax", limits.PidsMax)
+		m.onEvent(EventLimitExceeded, map[string]interface{}{
+			"id":       id,
+			"resource": "pids",
+			"limit":    limits.PidsMax,
+			"count":    n,
+		})
+	}
+	return ev
+}
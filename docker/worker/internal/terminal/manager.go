@@ -1,56 +1,259 @@
 package terminal
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"sync"
+	"syscall"
+	"time"
+	"worker/internal/cgroup"
+	"worker/internal/logging"
+	"worker/internal/process"
+	"worker/internal/sandbox"
 
 	"github.com/creack/pty"
 	"github.com/google/uuid"
+	"golang.org/x/sys/unix"
 )
 
+func logger() *slog.Logger {
+	return slog.With(logging.KeyComponent, "terminal")
+}
+
+// Size used when the frontend does not send one, matching xterm's default.
+const (
+	DefaultCols  = 80
+	DefaultRows  = 24
+	maxDimension = 1000
+
+	// closeGracePeriod is how long a terminal's processes have to exit after
+	// SIGHUP before they are killed.
+	closeGracePeriod = 2 * time.Second
+)
+
+// Lifecycle events reported through the Manager's event callback.
+const (
+	EventCreated = "terminal-created"
+	EventExit    = "terminal-exit"
+	EventClosed  = "terminal-closed"
+)
+
+// EventFunc receives terminal lifecycle events.
+type EventFunc func(event string, data map[string]interface{})
+
+// ExitStatus describes how a terminal's shell terminated.
+type ExitStatus struct {
+	// Code is the exit code, or 128+signal number if the shell was killed
+	// by a signal, following the shell convention.
+	Code   int
+	Signal string // e.g. "SIGHUP"; empty for a normal exit
+}
+
+type session struct {
+	ptmx *os.File
+	cmd  *exec.Cmd
+	size pty.Winsize
+	// exited is closed once the shell has been reaped.
+	exited chan struct{}
+	// group is the terminal's cgroup, nil when running without limits.
+	group *cgroup.Group
+	// recorder is nil unless terminals are being recorded.
+	recorder *recorder
+
+	// mu guards output delivery so that an attach sees every byte exactly
+	// once: either in the replayed scrollback or through the new onData.
+	mu         sync.Mutex
+	scrollback *scrollback
+	onData     func(data []byte)
+}
+
+func (s *session) deliver(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scrollback.Write(data)
+	if s.recorder != nil {
+		s.recorder.output(data)
+	}
+	s.onData(data)
+}
+
+// attach replaces the output callback and returns the buffered output.
+func (s *session) attach(onData func(data []byte)) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onData = onData
+	return s.scrollback.Bytes()
+}
+
 type Manager struct {
-	terminals map[string]*os.File
+	terminals map[string]*session
 	mu        sync.Mutex
+	onEvent   EventFunc
+	cgroups   *cgroup.Hierarchy
+	sandbox   *sandbox.Config
+	recordDir string
 }
 
-func NewManager() *Manager {
+// NewManager returns a Manager that reports lifecycle events to onEvent,
+// which may be nil.
+func NewManager(onEvent EventFunc) *Manager {
+	if onEvent == nil {
+		onEvent = func(string, map[string]interface{}) {}
+	}
 	return &Manager{
-		terminals: make(map[string]*os.File),
+		terminals: make(map[string]*session),
+		onEvent:   onEvent,
 	}
 }
 
+// SetCgroups makes new terminals run in child cgroups of h.
+func (m *Manager) SetCgroups(h *cgroup.Hierarchy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cgroups = h
+}
+
+// SetRecording records new terminals as asciicast v2 files in dir. An empty
+// dir turns recording off.
+func (m *Manager) SetRecording(dir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recordDir = dir
+}
+
+// SetSandbox makes new terminals run inside the namespace sandbox cfg.
+func (m *Manager) SetSandbox(cfg *sandbox.Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sandbox = cfg
+}
+
 func (m *Manager) Get(id string) (*os.File, bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	ptmx, ok := m.terminals[id]
-	return ptmx, ok
+	s, ok := m.terminals[id]
+	if !ok {
+		return nil, false
+	}
+	return s.ptmx, true
+}
+
+// Command describes the process a new terminal runs.
+type Command struct {
+	Path string   // Absolute path of the shell
+	Dir  string   // Working directory
+	Env  []string // Complete environment, as for exec.Cmd.Env
+	Cols uint16
+	Rows uint16
 }
 
-func (m *Manager) CreateOrGet(id string, cwd string, onData func(data []byte)) (string, *os.File, error) {
+// CreateOrGet returns the existing terminal with that ID, or starts c in a new
+// PTY once complete has filled in the rest of it. complete runs under the
+// manager's lock, only when a terminal is created, so one that exits in the
+// meantime is never replaced by a half-described command. A zero Cols or Rows
+// falls back to the default size; for an existing terminal a non-zero size
+// resizes it and onData replaces the previous callback.
+func (m *Manager) CreateOrGet(id string, c Command, complete func(c *Command) error, onData func(data []byte)) (string, *os.File, error) {
+	cols, rows := c.Cols, c.Rows
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if ptmx, ok := m.terminals[id]; ok {
-		return id, ptmx, nil
+	if s, ok := m.terminals[id]; ok {
+		if cols != 0 && rows != 0 {
+			if err := s.resize(cols, rows); err != nil {
+				return "", nil, err
+			}
+		}
+		s.attach(onData)
+		return id, s.ptmx, nil
 	}
 
 	if id == "" {
 		id = uuid.New().String()
 	}
+	if err := complete(&c); err != nil {
+		return "", nil, err
+	}
 
-	cmd := exec.Command("bash")
-	cmd.Dir = cwd
-	ptmx, err := pty.Start(cmd)
+	if cols == 0 || rows == 0 {
+		cols, rows = DefaultCols, DefaultRows
+	}
+	if err := validateSize(cols, rows); err != nil {
+		return "", nil, err
+	}
+	size := pty.Winsize{Cols: cols, Rows: rows}
+
+	var cmd *exec.Cmd
+	if m.sandbox != nil {
+		cmd = m.sandbox.Command(c.Dir, c.Path)
+	} else {
+		cmd = exec.Command(c.Path)
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	group, groupDir := m.newGroup()
+	if groupDir != nil {
+		// Start the shell inside its cgroup, so nothing it forks escapes.
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(groupDir.Fd())
+	}
+	ptmx, err := pty.StartWithSize(cmd, &size)
+	if groupDir != nil {
+		groupDir.Close()
+	}
 	if err != nil {
+		if group != nil {
+			group.Remove()
+		}
 		return "", nil, fmt.Errorf("failed to start pty: %w", err)
 	}
 
-	m.terminals[id] = ptmx
+	s := &session{
+		ptmx:       ptmx,
+		cmd:        cmd,
+		size:       size,
+		exited:     make(chan struct{}),
+		group:      group,
+		scrollback: newScrollback(DefaultScrollback),
+		onData:     onData,
+	}
+	created := map[string]interface{}{
+		"id":        id,
+		"pid":       cmd.Process.Pid,
+		"shell":     c.Path,
+		"cols":      cols,
+		"rows":      rows,
+		"sandboxed": m.sandbox != nil,
+	}
+	if m.recordDir != "" {
+		// A failed recording must not cost the learner their terminal.
+		if rec, err := newRecorder(m.recordDir, id, cols, rows, c.Path); err != nil {
+			logger().Warn("Failed to start terminal recording", "terminalId", id, "error", err)
+		} else {
+			s.recorder = rec
+			created["recording"] = filepath.Base(rec.path)
+		}
+	}
+	m.terminals[id] = s
+	logger().Info("Terminal created", "terminalId", id, "pid", cmd.Process.Pid, "shell", c.Path, "cwd", c.Dir, "cols", cols, "rows", rows, "sandboxed", m.sandbox != nil, "recording", created["recording"])
+	m.onEvent(EventCreated, created)
 
+	readerDone := make(chan struct{})
+	limitsDone := make(chan struct{})
+	if group != nil {
+		go m.watchLimits(id, s, limitsDone)
+	} else {
+		close(limitsDone)
+	}
+	go m.supervise(id, s, readerDone, limitsDone)
 	go func() {
-		defer m.Close(id) // Ensure cleanup when the reader exits.
+		defer close(readerDone)
 
 		buf := make([]byte, 4096)
 		for {
@@ -65,7 +268,7 @@ func (m *Manager) CreateOrGet(id string, cwd string, onData func(data []byte)) (
 				// Send a copy of the buffer slice to the callback.
 				dataCopy := make([]byte, n)
 				copy(dataCopy, buf[:n])
-				onData(dataCopy)
+				s.deliver(dataCopy)
 			}
 		}
 	}()
@@ -73,25 +276,245 @@ func (m *Manager) CreateOrGet(id string, cwd string, onData func(data []byte)) (
 	return id, ptmx, nil
 }
 
+// Attach redirects a terminal's output to onData and returns the scrollback
+// accumulated so far, so a reconnecting client can redraw the screen.
+func (m *Manager) Attach(id string, onData func(data []byte)) ([]byte, error) {
+	m.mu.Lock()
+	s, ok := m.terminals[id]
+	m.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("terminal not found: %s", id)
+	}
+	return s.attach(onData), nil
+}
+
+// Resize sets the window size of a terminal. The kernel delivers SIGWINCH to
+// the foreground process group so full-screen programs redraw.
+func (m *Manager) Resize(id string, cols, rows uint16) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.terminals[id]
+	if !ok {
+		return fmt.Errorf("terminal not found: %s", id)
+	}
+	return s.resize(cols, rows)
+}
+
+// Size returns the current window size of a terminal.
+func (m *Manager) Size(id string) (cols, rows uint16, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.terminals[id]
+	if !ok {
+		return 0, 0, false
+	}
+	return s.size.Cols, s.size.Rows, true
+}
+
+func (s *session) resize(cols, rows uint16) error {
+	if err := validateSize(cols, rows); err != nil {
+		return err
+	}
+	// Record first: the shell redraws as soon as the size changes, and the
+	// redraw must replay at the new size.
+	if s.recorder != nil {
+		s.recorder.resize(cols, rows)
+	}
+	size := pty.Winsize{Cols: cols, Rows: rows}
+	if err := pty.Setsize(s.ptmx, &size); err != nil {
+		return fmt.Errorf("failed to resize pty: %w", err)
+	}
+	s.size = size
+	return nil
+}
+
+func validateSize(cols, rows uint16) error {
+	if cols == 0 || rows == 0 || cols > maxDimension || rows > maxDimension {
+		return fmt.Errorf("invalid terminal size %dx%d", cols, rows)
+	}
+	return nil
+}
+
+// Count returns the number of live terminals.
+func (m *Manager) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.terminals)
+}
+
+// IDs returns the IDs of live terminals in sorted order.
+func (m *Manager) IDs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.terminals))
+	for id := range m.terminals {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 func (m *Manager) Write(id string, data string) error {
 	m.mu.Lock()
-	ptmx, ok := m.terminals[id]
+	s, ok := m.terminals[id]
 	m.mu.Unlock()
 
 	if !ok {
 		return fmt.Errorf("terminal not found: %s", id)
 	}
 
-	_, err := ptmx.Write([]byte(data))
+	if s.recorder != nil {
+		s.recorder.input([]byte(data))
+	}
+	_, err := s.ptmx.Write([]byte(data))
 	return err
 }
 
+// Signal sends sig to the terminal's foreground process group, as typing
+// Ctrl-C or Ctrl-\ would, falling back to the shell's own group.
+func (m *Manager) Signal(id string, sig syscall.Signal) error {
+	m.mu.Lock()
+	s, ok := m.terminals[id]
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("terminal not found: %s", id)
+	}
+	pgid := s.cmd.Process.Pid
+	if fg, err := s.foregroundGroup(); err == nil && fg > 0 {
+		pgid = fg
+	}
+	logger().Info("Signalling terminal", "terminalId", id, "signal", process.SignalName(sig), "pgid", pgid)
+	if err := syscall.Kill(-pgid, sig); err != nil {
+		return fmt.Errorf("failed to signal terminal %s: %w", id, err)
+	}
+	return nil
+}
+
+// foregroundGroup returns the process group in the foreground of the PTY.
+func (s *session) foregroundGroup() (int, error) {
+	// Go through SyscallConn rather than Fd, which would switch the PTY to
+	// blocking mode.
+	conn, err := s.ptmx.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var pgid int
+	var ioctlErr error
+	if err := conn.Control(func(fd uintptr) {
+		pgid, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	}); err != nil {
+		return 0, err
+	}
+	return pgid, ioctlErr
+}
+
+// Close hangs up every process in the terminal's session and closes its PTY.
+// Processes still running after a grace period are killed. The exit and
+// closed events follow once the shell has been reaped.
 func (m *Manager) Close(id string) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	s, ok := m.terminals[id]
+	if ok {
+		delete(m.terminals, id)
+	}
+	m.mu.Unlock()
 
-	if ptmx, ok := m.terminals[id]; ok {
-		ptmx.Close()
+	if !ok {
+		return
+	}
+	// Closing the PTY alone does not hang anything up: the reader goroutine's
+	// blocked Read keeps the descriptor open until every holder goes away.
+	// pty.Start makes the shell a session leader, so its PID is the session
+	// ID shared by every job it starts.
+	sid := s.cmd.Process.Pid
+	process.SignalSession(sid, syscall.SIGHUP)
+	s.ptmx.Close()
+	go func() {
+		select {
+		case <-s.exited:
+		case <-time.After(closeGracePeriod):
+			n, _ := process.SignalSession(sid, syscall.SIGKILL)
+			logger().Warn("Terminal ignored hangup, killed its processes", "terminalId", id, "sid", sid, "killed", n)
+		}
+	}()
+}
+
+// supervise reaps a terminal's shell, takes down whatever the shell left
+// running in its session, and reports the exit and closure once the output
+// has drained.
+func (m *Manager) supervise(id string, s *session, readerDone, limitsDone <-chan struct{}) {
+	status := waitStatus(s.cmd.Wait())
+	close(s.exited)
+
+	// Background jobs (a dev server, a nohup'd command) outlive the shell,
+	// may hold its port, and keep the PTY open.
+	sid := s.cmd.Process.Pid
+	if n, _ := process.SignalSession(sid, syscall.SIGHUP); n > 0 {
+		logger().Info("Hanging up processes left by terminal shell", "terminalId", id, "sid", sid, "count", n)
+		if !process.WaitSessionEmpty(sid, closeGracePeriod) {
+			n, _ := process.SignalSession(sid, syscall.SIGKILL)
+			logger().Warn("Killed processes left by terminal shell", "terminalId", id, "sid", sid, "killed", n)
+		}
+	}
+	if s.group != nil {
+		// Catch processes that left the session, e.g. daemons that called setsid.
+		if err := s.group.Kill(); err != nil {
+			logger().Warn("Failed to kill terminal cgroup", "terminalId", id, "error", err)
+		}
+	}
+	select {
+	case <-readerDone:
+	case <-time.After(closeGracePeriod):
+		logger().Warn("Terminal output still open after its session ended", "terminalId", id)
+	}
+	<-limitsDone
+	if s.group != nil {
+		if err := s.group.Remove(); err != nil {
+			logger().Warn("Failed to remove terminal cgroup", "terminalId", id, "error", err)
+		}
+	}
+
+	m.mu.Lock()
+	reason := "closed"
+	if m.terminals[id] == s {
+		// Still registered, so the shell exited on its own.
+		reason = "exited"
 		delete(m.terminals, id)
 	}
-}
\ No newline at end of file
+	m.mu.Unlock()
+	s.ptmx.Close()
+	if s.recorder != nil {
+		s.recorder.close()
+	}
+
+	logger().Info("Terminal exited", "terminalId", id, "code", status.Code, "signal", status.Signal, "reason", reason)
+	m.onEvent(EventExit, map[string]interface{}{
+		"id":     id,
+		"code":   status.Code,
+		"signal": status.Signal,
+	})
+	m.onEvent(EventClosed, map[string]interface{}{
+		"id":     id,
+		"reason": reason,
+	})
+}
+
+func waitStatus(err error) ExitStatus {
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		logger().Warn("Failed to wait for terminal shell", "error", err)
+		return ExitStatus{Code: -1}
+	}
+	if exitErr == nil {
+		return ExitStatus{Code: 0}
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		sig := ws.Signal()
+		return ExitStatus{Code: 128 + int(sig), Signal: unix.SignalName(sig)}
+	}
+	return ExitStatus{Code: exitErr.ExitCode()}
+}
@@ -0,0 +1,147 @@
+package terminal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Asciicast v2 event types.
+const (
+	castOutput = "o"
+	castInput  = "i"
+	castResize = "r"
+)
+
+var recordingNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// castHeader is the first line of an asciicast v2 file.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     uint16            `json:"width"`
+	Height    uint16            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// recorder writes a terminal session to an asciicast v2 file: a JSON header
+// followed by one [seconds, type, data] array per line.
+type recorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	path  string
+	start time.Time
+	// pending holds the incomplete UTF-8 sequence at the end of the last
+	// chunk of each stream, since asciicast data must be valid text.
+	pending map[string][]byte
+	failed  bool
+}
+
+// newRecorder creates a recording for terminal id in dir.
+func newRecorder(dir, id string, cols, rows uint16, shell string) (*recorder, error) {
+	name := id
+	if !recordingNamePattern.MatchString(name) {
+		// Terminal IDs come from the frontend, so only safe ones name files.
+		name = uuid.New().String()
+	}
+	start := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d.cast", name, start.Unix()))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: start.Unix(),
+		Title:     id,
+		Env:       map[string]string{"SHELL": shell, "TERM": "xterm-256color"},
+	})
+	if _, err := file.Write(append(header, '\n')); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write recording header: %w", err)
+	}
+	return &recorder{file: file, path: path, start: start, pending: make(map[string][]byte)}, nil
+}
+
+func (r *recorder) output(data []byte) {
+	r.write(castOutput, data)
+}
+
+func (r *recorder) input(data []byte) {
+	r.write(castInput, data)
+}
+
+func (r *recorder) resize(cols, rows uint16) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event(castResize, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (r *recorder) write(kind string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buf := append(r.pending[kind], data...)
+	cut := completeUTF8(buf)
+	r.pending[kind] = append([]byte(nil), buf[cut:]...)
+	if cut > 0 {
+		r.event(kind, string(buf[:cut]))
+	}
+}
+
+// event appends one event line. The caller holds r.mu.
+func (r *recorder) event(kind, data string) {
+	if r.file == nil || r.failed {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	line, _ := json.Marshal([]interface{}{json.Number(strconv.FormatFloat(elapsed, 'f', 6, 64)), kind, data})
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		// Stop rather than leave a recording with holes in it.
+		r.failed = true
+		logger().Warn("Failed to write terminal recording, stopping it", "path", r.path, "error", err)
+	}
+}
+
+// close flushes any incomplete UTF-8 as replacement characters and closes
+// the file.
+func (r *recorder) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for kind, rest := range r.pending {
+		if len(rest) > 0 {
+			r.event(kind, string(rest))
+		}
+	}
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+// completeUTF8 returns the length of the longest prefix of b that does not
+// end in the middle of a UTF-8 sequence.
+func completeUTF8(b []byte) int {
+	// A sequence is at most 4 bytes, so only the last 3 can be incomplete.
+	for i := len(b) - 1; i >= 0 && i >= len(b)-3; i-- {
+		if !utf8.RuneStart(b[i]) {
+			continue
+		}
+		if !utf8.FullRune(b[i:]) {
+			return i
+		}
+		break
+	}
+	return len(b)
+}
@@ -0,0 +1,104 @@
+package terminal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCompleteUTF8(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "abc", 3},
+		{"complete two byte", "aé", 3},
+		{"complete four byte", "a😀", 5},
+		{"split two byte", "a\xc3", 1},
+		{"split three byte after one", "a\xe2", 1},
+		{"split three byte after two", "a\xe2\x82", 1},
+		{"split four byte after one", "\xf0", 0},
+		{"split four byte after three", "ab\xf0\x9f\x98", 2},
+		{"complete after split one", "\xf0\x9f\x98\x80\xc3", 4},
+		// Invalid bytes cannot become valid later, so they are not held back.
+		{"stray continuation", "a\x80", 2},
+		{"continuations past a sequence", "a\x80\x80\x80\x80", 5},
+		{"invalid lead byte", "a\xff", 2},
+		{"lead byte then ascii", "\xe2a", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := completeUTF8([]byte(tt.in)); got != tt.want {
+				t.Errorf("completeUTF8(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRecorderSplitUTF8 splits text at every byte and checks each event is
+// valid UTF-8 and the events add up to the text.
+func TestRecorderSplitUTF8(t *testing.T) {
+	text := "aé€😀z"
+	for cut := 0; cut <= len(text); cut++ {
+		r, err := newRecorder(t.TempDir(), "test", 80, 24, "/bin/sh")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.output([]byte(text[:cut]))
+		r.output([]byte(text[cut:]))
+		r.close()
+
+		events := readEvents(t, r.path)
+		if got := strings.Join(events, ""); got != text {
+			t.Errorf("cut at %d: recorded %q, want %q", cut, got, text)
+		}
+		for _, data := range events {
+			if !utf8.ValidString(data) {
+				t.Errorf("cut at %d: event %q is not valid UTF-8", cut, data)
+			}
+		}
+	}
+}
+
+func TestRecorderFlushesIncompleteUTF8(t *testing.T) {
+	r, err := newRecorder(t.TempDir(), "test", 80, 24, "/bin/sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.output([]byte("ok\xe2\x82"))
+	r.close()
+
+	// Each byte of the incomplete sequence becomes a replacement character.
+	events := readEvents(t, r.path)
+	if len(events) != 2 || events[0] != "ok" || events[1] != "\ufffd\ufffd" {
+		t.Errorf("events = %q, want [\"ok\" \"\\ufffd\\ufffd\"]", events)
+	}
+}
+
+// readEvents returns the data of the output events of a recording.
+func readEvents(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []string
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("bad event %q: %v", scanner.Text(), err)
+		}
+		if len(event) == 3 && event[1] == castOutput {
+			events = append(events, event[2].(string))
+		}
+	}
+	return events
+}
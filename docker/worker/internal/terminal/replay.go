@@ -0,0 +1,266 @@
+package terminal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Replay modes.
+const (
+	// ReplayInput types the recorded input into a fresh shell, re-executing
+	// the recorded commands so the terminal ends up in the same state.
+	ReplayInput = "input"
+	// ReplayOutput emits the recorded output verbatim, without a shell.
+	ReplayOutput = "output"
+)
+
+// EventReplayDone is reported when a replay finishes, is cancelled or fails.
+const EventReplayDone = "terminal-replay-done"
+
+// CastEvent is one asciicast v2 event: seconds since the start, type ("o",
+// "i" or "r") and data.
+type CastEvent struct {
+	Time float64
+	Kind string
+	Data string
+}
+
+func (e *CastEvent) UnmarshalJSON(b []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("event must have 3 fields, got %d", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return fmt.Errorf("invalid event time: %w", err)
+	}
+	if err := json.Unmarshal(fields[1], &e.Kind); err != nil {
+		return fmt.Errorf("invalid event type: %w", err)
+	}
+	return json.Unmarshal(fields[2], &e.Data)
+}
+
+// Recording is a parsed terminal recording.
+type Recording struct {
+	Cols   uint16
+	Rows   uint16
+	Events []CastEvent
+}
+
+// ParseRecording reads an asciicast v2 file.
+func ParseRecording(r io.Reader) (*Recording, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return nil, errors.New("recording is empty")
+	}
+	var header castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("invalid recording header: %w", err)
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+	rec := &Recording{Cols: header.Width, Rows: header.Height}
+	for line := 2; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var ev CastEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("invalid event on line %d: %w", line, err)
+		}
+		rec.Events = append(rec.Events, ev)
+	}
+	return rec, scanner.Err()
+}
+
+// OpenRecording loads a recording made by this worker, by file name.
+func (s *Service) OpenRecording(name string) (*Recording, error) {
+	s.mu.Lock()
+	dir := s.recordDir
+	s.mu.Unlock()
+	if dir == "" {
+		return nil, errors.New("terminal recording is not enabled")
+	}
+	if name != filepath.Base(name) || !strings.HasSuffix(name, ".cast") {
+		return nil, fmt.Errorf("invalid recording name %q", name)
+	}
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer f.Close()
+	return ParseRecording(f)
+}
+
+// ReplayOptions control the pace and extent of a replay.
+type ReplayOptions struct {
+	Mode string
+	// Speed multiplies the recorded pace; 0 replays without delays.
+	Speed float64
+	// Until stops the replay at this many seconds into the recording; 0
+	// replays all of it.
+	Until float64
+}
+
+// ReplayResult describes how a replay ended.
+type ReplayResult struct {
+	Played    int // Events replayed
+	Cancelled bool
+	Err       error
+}
+
+// ReplayCallbacks receive a replay's effects.
+type ReplayCallbacks struct {
+	Output func(data []byte)
+	Resize func(cols, rows uint16)
+	Done   func(result ReplayResult)
+}
+
+// Replay starts replaying rec as terminal id and returns the ID once the
+// replay is running. In input mode it creates a new terminal from opts,
+// sized as recorded, which stays open afterwards for the learner to use.
+func (s *Service) Replay(id string, rec *Recording, ro ReplayOptions, opts Options, cb ReplayCallbacks) (string, error) {
+	if ro.Speed < 0 {
+		return "", fmt.Errorf("invalid replay speed %v", ro.Speed)
+	}
+	if id == "" {
+		id = uuid.New().String()
+	}
+	if _, exists := s.manager.Get(id); exists {
+		return "", fmt.Errorf("terminal %s already exists", id)
+	}
+
+	var apply func(ev CastEvent) error
+	switch ro.Mode {
+	case ReplayInput:
+		if opts.Cols == 0 || opts.Rows == 0 {
+			opts.Cols, opts.Rows = rec.Cols, rec.Rows
+		}
+		var err error
+		if id, err = s.CreateOrGetTerminal(id, opts, cb.Output); err != nil {
+			return "", err
+		}
+		apply = func(ev CastEvent) error {
+			switch ev.Kind {
+			case castInput:
+				return s.manager.Write(id, ev.Data)
+			case castResize:
+				cols, rows, err := parseCastSize(ev.Data)
+				if err != nil {
+					return err
+				}
+				if err := s.manager.Resize(id, cols, rows); err != nil {
+					return err
+				}
+				cb.Resize(cols, rows)
+			}
+			return nil
+		}
+	case ReplayOutput:
+		if rec.Cols != 0 && rec.Rows != 0 {
+			cb.Resize(rec.Cols, rec.Rows)
+		}
+		apply = func(ev CastEvent) error {
+			switch ev.Kind {
+			case castOutput:
+				cb.Output([]byte(ev.Data))
+			case castResize:
+				cols, rows, err := parseCastSize(ev.Data)
+				if err != nil {
+					return err
+				}
+				cb.Resize(cols, rows)
+			}
+			return nil
+		}
+	default:
+		return "", fmt.Errorf("unknown replay mode %q", ro.Mode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	if _, busy := s.replays[id]; busy {
+		s.mu.Unlock()
+		cancel()
+		return "", fmt.Errorf("terminal %s is already replaying", id)
+	}
+	s.replays[id] = cancel
+	s.mu.Unlock()
+
+	logger().Info("Replaying terminal recording", "terminalId", id, "mode", ro.Mode, "events", len(rec.Events), "speed", ro.Speed, "until", ro.Until)
+	go func() {
+		defer cancel()
+		result := play(ctx, rec.Events, ro, apply)
+		s.mu.Lock()
+		delete(s.replays, id)
+		s.mu.Unlock()
+		logger().Info("Terminal replay finished", "terminalId", id, "played", result.Played, "cancelled", result.Cancelled, "error", result.Err)
+		cb.Done(result)
+	}()
+	return id, nil
+}
+
+// CancelReplay stops a running replay. The terminal itself stays open.
+func (s *Service) CancelReplay(id string) bool {
+	s.mu.Lock()
+	cancel, ok := s.replays[id]
+	s.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// play applies events in order, keeping the recorded gaps between them
+// scaled by ro.Speed. Waits are measured from the start of the replay so
+// that delays do not accumulate.
+func play(ctx context.Context, events []CastEvent, ro ReplayOptions, apply func(ev CastEvent) error) ReplayResult {
+	var result ReplayResult
+	start := time.Now()
+	for _, ev := range events {
+		if ro.Until > 0 && ev.Time > ro.Until {
+			break
+		}
+		if ro.Speed > 0 {
+			due := start.Add(time.Duration(ev.Time / ro.Speed * float64(time.Second)))
+			timer := time.NewTimer(time.Until(due))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				result.Cancelled = true
+				return result
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			result.Cancelled = true
+			return result
+		}
+		if err := apply(ev); err != nil {
+			result.Err = err
+			return result
+		}
+		result.Played++
+	}
+	return result
+}
+
+func parseCastSize(s string) (cols, rows uint16, err error) {
+	if _, err := fmt.Sscanf(s, "%dx%d", &cols, &rows); err != nil {
+		return 0, 0, fmt.Errorf("invalid resize event %q", s)
+	}
+	return cols, rows, nil
+}
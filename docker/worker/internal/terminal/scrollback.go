@@ -0,0 +1,50 @@
+package terminal
+
+import "unicode/utf8"
+
+// DefaultScrollback is the number of bytes of output kept per terminal.
+const DefaultScrollback = 256 * 1024
+
+// scrollback is a fixed-size ring of the most recent terminal output.
+type scrollback struct {
+	buf  []byte
+	next int  // Index of the next byte to write
+	full bool // Whether buf has wrapped at least once
+}
+
+func newScrollback(size int) *scrollback {
+	return &scrollback{buf: make([]byte, size)}
+}
+
+func (s *scrollback) Write(p []byte) {
+	if len(p) >= len(s.buf) {
+		copy(s.buf, p[len(p)-len(s.buf):])
+		s.next = 0
+		s.full = true
+		return
+	}
+	n := copy(s.buf[s.next:], p)
+	if n < len(p) {
+		copy(s.buf, p[n:])
+		s.full = true
+	}
+	s.next = (s.next + len(p)) % len(s.buf)
+	if s.next == 0 {
+		s.full = true
+	}
+}
+
+// Bytes returns a copy of the buffered output, oldest first. Once the ring
+// has wrapped, a UTF-8 sequence cut at the start is dropped.
+func (s *scrollback) Bytes() []byte {
+	if !s.full {
+		return append([]byte(nil), s.buf[:s.next]...)
+	}
+	out := make([]byte, 0, len(s.buf))
+	out = append(out, s.buf[s.next:]...)
+	out = append(out, s.buf[:s.next]...)
+	for i := 0; i < utf8.UTFMax && len(out) > 0 && !utf8.RuneStart(out[0]); i++ {
+		out = out[1:]
+	}
+	return out
+}
@@ -1,35 +1,148 @@
 package terminal
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"worker/internal/cgroup"
+	"worker/internal/process"
+	"worker/internal/sandbox"
 	"worker/pkg/types"
 )
 
 type Service struct {
-	manager      *Manager
-	workspaceDir string
+	manager       *Manager
+	workspaceDir  string
+	allowedShells []string
+
+	mu        sync.Mutex
+	recordDir string
+	replays   map[string]context.CancelFunc // By terminal ID
+}
+
+// Options configure a new terminal. Zero values select the defaults.
+type Options struct {
+	Shell string            // Name from the shell allowlist
+	Cwd   string            // Absolute working directory, already confined to the workspace
+	Env   map[string]string // Added to the sanitized base environment
+	Cols  uint16
+	Rows  uint16
 }
 
-func NewService(workspaceDir string) *Service {
+// NewService returns a terminal service that reports terminal lifecycle
+// events (created, exit, closed) through emit.
+func NewService(workspaceDir string, emit func(msg *types.Message)) *Service {
 	return &Service{
-		manager:      NewManager(),
-		workspaceDir: workspaceDir,
+		manager: NewManager(func(event string, data map[string]interface{}) {
+			emit(&types.Message{Event: event, Data: data})
+		}),
+		workspaceDir:  workspaceDir,
+		allowedShells: DefaultAllowedShells,
+		replays:       make(map[string]context.CancelFunc),
+	}
+}
+
+// SetCgroups confines new terminals to child cgroups of h.
+func (s *Service) SetCgroups(h *cgroup.Hierarchy) {
+	s.manager.SetCgroups(h)
+}
+
+// SetRecording records new terminals as asciicast v2 files in dir.
+func (s *Service) SetRecording(dir string) {
+	s.mu.Lock()
+	s.recordDir = dir
+	s.mu.Unlock()
+	s.manager.SetRecording(dir)
+}
+
+// SetSandbox runs new terminals inside the namespace sandbox cfg.
+func (s *Service) SetSandbox(cfg *sandbox.Config) {
+	s.manager.SetSandbox(cfg)
+}
+
+// SetAllowedShells replaces the shells terminals may run. Names are trimmed
+// of spaces, so "bash, zsh" works, and empty ones are dropped.
+func (s *Service) SetAllowedShells(names []string) {
+	allowed := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			allowed = append(allowed, name)
+		}
 	}
+	s.allowedShells = allowed
+}
+
+// CreateOrGetTerminal opens a terminal as described by opts, or reattaches to
+// an existing one, in which case only the size in opts applies.
+func (s *Service) CreateOrGetTerminal(id string, opts Options, onData func(data []byte)) (string, error) {
+	c := Command{Cols: opts.Cols, Rows: opts.Rows}
+	createdID, _, err := s.manager.CreateOrGet(id, c, func(c *Command) error {
+		shellPath, err := resolveShell(opts.Shell, s.allowedShells)
+		if err != nil {
+			return err
+		}
+		env, err := buildEnv(shellPath, opts.Env)
+		if err != nil {
+			return err
+		}
+		c.Path, c.Env, c.Dir = shellPath, env, opts.Cwd
+		if c.Dir == "" {
+			c.Dir = s.defaultDir()
+		}
+		if info, err := os.Stat(c.Dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("working directory %s is not a directory", c.Dir)
+		}
+		return nil
+	}, onData)
+	return createdID, err
 }
 
-func (s *Service) CreateOrGetTerminal(id string, onData func(data []byte)) (string, error) {
+// defaultDir is where terminals and commands start unless told otherwise.
+func (s *Service) defaultDir() string {
 	if s.workspaceDir == "./" {
-		createdID, _, err := s.manager.CreateOrGet(id, "/workspace", onData)
-		return createdID, err
-	} else {
-		createdID, _, err := s.manager.CreateOrGet(id, s.workspaceDir, onData)
-		return createdID, err
+		return "/workspace"
 	}
+	return s.workspaceDir
 }
 
 func (s *Service) WriteToTerminal(data types.TerminalInput) error {
 	return s.manager.Write(data.ID, data.Input)
 }
 
+// AttachTerminal redirects a terminal's output to onData and returns its scrollback.
+func (s *Service) AttachTerminal(id string, onData func(data []byte)) ([]byte, error) {
+	return s.manager.Attach(id, onData)
+}
+
+// SignalTerminal sends a named signal to the terminal's foreground job.
+func (s *Service) SignalTerminal(req types.TerminalSignal) error {
+	sig, err := process.ParseSignal(req.Signal)
+	if err != nil {
+		return err
+	}
+	return s.manager.Signal(req.ID, sig)
+}
+
+func (s *Service) ResizeTerminal(req types.TerminalResize) error {
+	return s.manager.Resize(req.ID, req.Cols, req.Rows)
+}
+
+// TerminalSize returns the current size of a terminal.
+func (s *Service) TerminalSize(id string) (cols, rows uint16, ok bool) {
+	return s.manager.Size(id)
+}
+
 func (s *Service) CloseTerminal(id string) {
+	s.CancelReplay(id)
 	s.manager.Close(id)
 }
+
+func (s *Service) TerminalCount() int {
+	return s.manager.Count()
+}
+
+func (s *Service) ListTerminals() []string {
+	return s.manager.IDs()
+}
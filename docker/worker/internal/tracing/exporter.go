@@ -0,0 +1,123 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Exporter receives every finished span. Implementations must be safe for
+// concurrent use; Export is called synchronously from Span.End.
+type Exporter interface {
+	Export(span SpanData)
+	Shutdown() error
+}
+
+var (
+	mu       sync.RWMutex
+	exporter Exporter = noopExporter{}
+	service  string
+)
+
+// SetExporter replaces the global exporter and returns the previous one.
+func SetExporter(e Exporter) Exporter {
+	if e == nil {
+		e = noopExporter{}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	prev := exporter
+	exporter = e
+	return prev
+}
+
+func currentExporter() Exporter {
+	mu.RLock()
+	defer mu.RUnlock()
+	return exporter
+}
+
+func serviceName() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return service
+}
+
+// Init configures tracing for the process from the environment:
+//
+//	TRACE_EXPORTER=none (default) | stdout | file
+//	TRACE_FILE=<path>   destination for the file exporter (default traces.jsonl)
+//
+// It returns the installed exporter so the caller can Shutdown it on exit.
+func Init(serviceName string) (Exporter, error) {
+	mu.Lock()
+	service = serviceName
+	mu.Unlock()
+
+	var e Exporter
+	switch strings.ToLower(os.Getenv("TRACE_EXPORTER")) {
+	case "", "none":
+		e = noopExporter{}
+	case "stdout":
+		e = NewWriterExporter(os.Stdout)
+	case "file":
+		path := os.Getenv("TRACE_FILE")
+		if path == "" {
+			path = "traces.jsonl"
+		}
+		fe, err := NewFileExporter(path)
+		if err != nil {
+			return nil, err
+		}
+		e = fe
+	default:
+		return nil, fmt.Errorf("unknown TRACE_EXPORTER %q", os.Getenv("TRACE_EXPORTER"))
+	}
+	SetExporter(e)
+	return e, nil
+}
+
+type noopExporter struct{}
+
+func (noopExporter) Export(SpanData) {}
+func (noopExporter) Shutdown() error { return nil }
+
+// WriterExporter writes one JSON object per span, one per line.
+type WriterExporter struct {
+	w      io.Writer
+	closer io.Closer
+	mu     sync.Mutex
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// NewFileExporter appends spans as JSON lines to the file at path.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file %s: %w", path, err)
+	}
+	return &WriterExporter{w: f, closer: f}, nil
+}
+
+func (e *WriterExporter) Export(span SpanData) {
+	line, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(append(line, '\n'))
+}
+
+func (e *WriterExporter) Shutdown() error {
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package watcher
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+	"worker/internal/logging"
 
 	"github.com/fsnotify/fsnotify"
 )
@@ -12,6 +15,7 @@ import (
 type watchInfo struct {
 	isExplicitlyWatched bool
 	fileReferenceCount  int 
+	indexed             bool // Watched for the file index rather than the explorer
 }
 
 type Service struct {
@@ -19,6 +23,20 @@ type Service struct {
 	watched  map[string]*watchInfo // Map of path -> watchInfo
 	rootPath string
 	mu       sync.Mutex
+
+	// Listeners receive every event, including those from directories
+	// watched only for the file index.
+	listeners []func(event fsnotify.Event)
+
+	// Health bookkeeping, reported by Health
+	closed     bool
+	errorCount int
+	lastErr    error
+	lastErrAt  time.Time
+}
+
+func logger() *slog.Logger {
+	return slog.With(logging.KeyComponent, "watcher")
 }
 
 func NewService(rootPath string) (*Service, error) {
@@ -47,15 +65,34 @@ func (s *Service) translatePath(path string) string {
 	return filepath.Join(s.rootPath, cleanPath)
 }
 
+// AddListener registers fn to receive every event handled by the event loop.
+func (s *Service) AddListener(fn func(event fsnotify.Event)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// StartEventLoop passes events to the listeners, and to onEvent those from
+// directories the explorer watches.
 func (s *Service) StartEventLoop(onEvent func(event fsnotify.Event)) {
 	for {
 		select {
 		case event, ok := <-s.watcher.Events:
 			if !ok { return }
-			onEvent(event)
+			s.mu.Lock()
+			listeners := s.listeners
+			explorer := s.explorerWatchesLocked(event.Name)
+			s.mu.Unlock()
+			for _, fn := range listeners {
+				fn(event)
+			}
+			if explorer {
+				onEvent(event)
+			}
 		case err, ok := <-s.watcher.Errors:
 			if !ok { return }
-			log.Printf("[WATCHER] Error: %v", err)
+			logger().Error("Watcher error", "error", err)
+			s.recordError(err)
 		}
 	}
 }
@@ -73,9 +110,10 @@ func (s *Service) Watch(path string) {
 		s.watched[actualPath] = info
 		// If it wasn't watched for any reason before, add it to fsnotify.
 		if err := s.watcher.Add(actualPath); err != nil {
-			log.Printf("WATCHER: Failed to add watch on %s: %v", actualPath, err)
+			logger().Warn("Failed to add watch", "path", actualPath, "error", err)
+			s.recordErrorLocked(err)
 		} else {
-			log.Printf("WATCHER: Started monitoring directory: %s (explicit)", actualPath)
+			logger().Debug("Started monitoring directory", "path", actualPath, "reason", "explicit")
 		}
 	}
 	info.isExplicitlyWatched = true
@@ -108,9 +146,10 @@ func (s *Service) AddFileReference(filePath string) {
 		s.watched[parentDir] = info
 		// If it wasn't watched before, add it to fsnotify.
 		if err := s.watcher.Add(parentDir); err != nil {
-			log.Printf("WATCHER: Failed to add watch on %s: %v", parentDir, err)
+			logger().Warn("Failed to add watch", "path", parentDir, "error", err)
+			s.recordErrorLocked(err)
 		} else {
-			log.Printf("WATCHER: Started monitoring directory: %s (by file reference)", parentDir)
+			logger().Debug("Started monitoring directory", "path", parentDir, "reason", "file-reference")
 		}
 	}
 	info.fileReferenceCount++
@@ -132,6 +171,46 @@ func (s *Service) RemoveFileReference(filePath string) {
 	}
 }
 
+// WatchIndexed watches a directory for the file index. Unlike Watch it takes
+// the directory's actual path.
+func (s *Service) WatchIndexed(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, exists := s.watched[path]
+	if !exists {
+		if err := s.watcher.Add(path); err != nil {
+			return err
+		}
+		info = &watchInfo{}
+		s.watched[path] = info
+	}
+	info.indexed = true
+	return nil
+}
+
+// UnwatchIndexed stops watching a directory for the file index.
+func (s *Service) UnwatchIndexed(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if info, exists := s.watched[path]; exists {
+		info.indexed = false
+		s.checkAndRemoveWatch(path)
+	}
+}
+
+// explorerWatchesLocked reports whether an event for path comes from a
+// directory watched for the explorer, either path itself or its parent.
+func (s *Service) explorerWatchesLocked(path string) bool {
+	for _, dir := range []string{path, filepath.Dir(path)} {
+		if info, exists := s.watched[dir]; exists && (info.isExplicitlyWatched || info.fileReferenceCount > 0) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Service) checkAndRemoveWatch(path string) {
 	// Never remove the root path watcher.
 	if path == s.rootPath {
@@ -139,18 +218,67 @@ func (s *Service) checkAndRemoveWatch(path string) {
 	}
 	
 	if info, exists := s.watched[path]; exists {
-		// Only remove the watch if it's not explicitly watched AND has no more file references.
-		if !info.isExplicitlyWatched && info.fileReferenceCount == 0 {
+		// Only remove the watch if it's not explicitly watched, has no more file
+		// references and is not needed by the file index.
+		if !info.isExplicitlyWatched && info.fileReferenceCount == 0 && !info.indexed {
 			if err := s.watcher.Remove(path); err != nil {
-				log.Printf("WATCHER: Failed to remove watch on %s: %v", path, err)
+				logger().Warn("Failed to remove watch", "path", path, "error", err)
 			} else {
-				log.Printf("WATCHER: Stopped monitoring directory: %s", path)
+				logger().Debug("Stopped monitoring directory", "path", path)
 			}
 			delete(s.watched, path)
 		}
 	}
 }
 
+// WatchCount returns the number of directories currently monitored.
+func (s *Service) WatchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.watched)
+}
+
+// recentErrorWindow is how long a watcher error keeps the service unhealthy.
+const recentErrorWindow = time.Minute
+
+func (s *Service) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordErrorLocked(err)
+}
+
+func (s *Service) recordErrorLocked(err error) {
+	s.errorCount++
+	s.lastErr = err
+	s.lastErrAt = time.Now()
+}
+
+// Health reports whether the watcher is usable. It fails once closed, or for
+// a short while after fsnotify reports an error (e.g. an event queue overflow).
+func (s *Service) Health() (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	details := map[string]interface{}{
+		"watchedDirectories": len(s.watched),
+		"errorCount":         s.errorCount,
+	}
+	if s.lastErr != nil {
+		details["lastError"] = s.lastErr.Error()
+		details["lastErrorAt"] = s.lastErrAt
+	}
+	if s.closed {
+		return details, fmt.Errorf("watcher is closed")
+	}
+	if s.lastErr != nil && time.Since(s.lastErrAt) < recentErrorWindow {
+		return details, fmt.Errorf("recent watcher error: %w", s.lastErr)
+	}
+	return details, nil
+}
+
 func (s *Service) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
 	s.watcher.Close()
 }
@@ -1,30 +1,113 @@
 package ws
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"path"
 	"strings"
+	"sync"
+	"time"
 	"worker/internal/config"
 	"worker/internal/filesystem"
+	"worker/internal/logging"
+	"worker/internal/metrics"
 	"worker/internal/terminal"
+	"worker/internal/tracing"
 	"worker/internal/watcher"
 	"worker/pkg/types"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+var messagesTotal = metrics.NewCounterVec("worker_messages_total", "Messages exchanged with the bridge, by direction and event type.", "direction", "event")
+
+// eventLabels are the events counted under their own name in messagesTotal:
+// the commands routeMessage handles and the events the worker sends. Any
+// other name comes from a client and is counted as "unknown", so clients
+// cannot create unbounded series.
+var eventLabels = map[string]bool{
+	// Commands
+	"init": true, "create-initial-commit": true, "hydrate-create-file": true,
+	"crud-read-folder": true, "crud-stat": true, "crud-collapse-folder": true,
+	"crud-read-file": true, "crud-write-chunk": true, "crud-close-file": true,
+	"crud-update-file": true, "crud-patch-file": true, "crud-create-file": true,
+	"crud-create-folder": true, "crud-delete-resource": true, "crud-list-trash": true,
+	"crud-restore-resource": true, "crud-move-resource": true, "crud-copy-resource": true,
+	"create-terminal": true, "terminal-input": true, "attach-terminal": true,
+	"terminal-signal": true, "terminal-resize": true, "close-terminal": true,
+	"list-terminals": true, "replay-terminal": true, "cancel-replay": true, "exec": true,
+	"crud-search": true, "crud-find-files": true, "crud-replace": true, "cancel-search": true,
+	"watch": true, "command-preview": true, "command-run": true, "crud-download-workspace": true,
+	"system:checkout": true, "system:create-branch": true, "system:commit": true,
+	"system:save-branch": true, "hydration-complete": true, "client-disconnected": true,
+	// Events sent by the worker
+	"add": true, "addDir": true, "change": true, "unlink": true, "unlinkDir": true, "rename": true,
+	"command-result-preview": true, "command-result-run": true, "download-workspace": true,
+	"exec-output": true, "file-chunk": true, "search-results": true, "terminal-created": true,
+	"terminal-data": true, "terminal-exit": true, "terminal-closed": true, "terminal-oom": true,
+	"terminal-replay-done": true, "terminal-resized": true, "limit-exceeded": true,
+	"workspace:commit": true,
+}
+
+// eventLabel returns the metric label for event.
+func eventLabel(event string) string {
+	if eventLabels[event] {
+		return event
+	}
+	return "unknown"
+}
+
 type Handler struct {
 	hub      *Hub
 	fsSvc    *filesystem.Service
 	termSvc  *terminal.Service
 	watchSvc *watcher.Service
+	index    *filesystem.Index
+
+	hydrationMu    sync.Mutex
+	hydrationState string
+
+	searchMu sync.Mutex
+	searches map[string]*search // Running searches by client and search ID
+	execs    map[string]*search // Running execs by client and exec ID, also under searchMu
 }
 
-func NewHandler(hub *Hub, fsSvc *filesystem.Service, termSvc *terminal.Service, watchSvc *watcher.Service) *Handler {
-	return &Handler{hub: hub, fsSvc: fsSvc, termSvc: termSvc, watchSvc: watchSvc}
+// search is a running crud-search, cancelled when a newer one replaces it,
+// or a running exec.
+type search struct {
+	cancel context.CancelFunc
+}
+
+// Hydration states reported by HydrationState.
+const (
+	HydrationPending    = "pending" // No hydrated file received yet
+	HydrationInProgress = "in_progress"
+	HydrationComplete   = "complete"
+)
+
+func NewHandler(hub *Hub, fsSvc *filesystem.Service, termSvc *terminal.Service, watchSvc *watcher.Service, index *filesystem.Index) *Handler {
+	return &Handler{hub: hub, fsSvc: fsSvc, termSvc: termSvc, watchSvc: watchSvc, index: index, hydrationState: HydrationPending, searches: make(map[string]*search), execs: make(map[string]*search)}
+}
+
+// HydrationState reports whether the bridge has finished hydrating the workspace.
+func (h *Handler) HydrationState() string {
+	h.hydrationMu.Lock()
+	defer h.hydrationMu.Unlock()
+	return h.hydrationState
+}
+
+func (h *Handler) setHydrationState(state string) {
+	h.hydrationMu.Lock()
+	defer h.hydrationMu.Unlock()
+	h.hydrationState = state
 }
 
 var upgrader = websocket.Upgrader{
@@ -36,10 +119,11 @@ var upgrader = websocket.Upgrader{
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		slog.Warn("WebSocket upgrade failed", "remoteAddr", r.RemoteAddr, "error", err)
 		return
 	}
-	client := &Client{hub: h.hub, Conn: conn, Send: make(chan *types.Message, 256)}
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &Client{hub: h.hub, Conn: conn, Send: make(chan *types.Message, 256), ctx: ctx, cancel: cancel}
 	client.hub.Register <- client
 
 	go client.writePump()
@@ -51,10 +135,15 @@ type Client struct {
 	Conn *websocket.Conn
 	Send chan *types.Message
 	Mode string
+	// ctx is cancelled when the bridge disconnects, stopping the execs it
+	// started.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func (c *Client) readPump(h *Handler) {
 	defer func() {
+		c.cancel()
 		c.hub.Unregister <- c
 		c.Conn.Close()
 	}()
@@ -67,9 +156,10 @@ func (c *Client) readPump(h *Handler) {
 
 		var msg types.Message
 		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("[WORKER] Error unmarshaling message: %v", err)
+			slog.Warn("Error unmarshaling message", "error", err)
 			continue
 		}
+		messagesTotal.WithLabelValues("in", eventLabel(msg.Event)).Inc()
 
 		go h.routeMessage(c, msg)
 	}
@@ -83,8 +173,27 @@ func (c *Client) writePump() {
 			c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 			return
 		}
-		c.Conn.WriteJSON(message)
+		if err := c.Conn.WriteJSON(message); err == nil {
+			messagesTotal.WithLabelValues("out", eventLabel(message.Event)).Inc()
+		}
+	}
+}
+
+// messageLogger returns a logger carrying the correlation fields set by the bridge.
+func messageLogger(ctx context.Context, msg types.Message, ackID string) *slog.Logger {
+	logger := slog.With(logging.KeyEvent, msg.Event, logging.KeyTraceID, tracing.TraceIDFromContext(ctx))
+	if msg.Meta != nil {
+		if msg.Meta.WorkspaceID != "" {
+			logger = logger.With(logging.KeyWorkspaceID, msg.Meta.WorkspaceID)
+		}
+		if msg.Meta.ClientID != "" {
+			logger = logger.With(logging.KeyClientID, msg.Meta.ClientID)
+		}
+	}
+	if ackID != "" {
+		logger = logger.With(logging.KeyAckID, ackID)
 	}
+	return logger
 }
 
 func (h *Handler) routeMessage(client *Client, msg types.Message) {
@@ -100,6 +209,20 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 	}
 	ack.Data = map[string]interface{}{"ackID": reqAckID}
 
+	// Continue the trace started upstream by the bridge, if any.
+	ctx := context.Background()
+	if msg.Meta != nil {
+		ctx = tracing.ContextWithRemoteParent(ctx, msg.Meta.TraceParent)
+	}
+	ctx, span := tracing.Start(ctx, "worker.route", "event", msg.Event, "ackId", reqAckID)
+	defer func() {
+		if ack.Error != "" {
+			span.RecordError(errors.New(ack.Error))
+		}
+		span.End()
+	}()
+	logger := messageLogger(ctx, msg, reqAckID)
+
 	switch msg.Event {
 	case "init":
 		// Extract mode from init message
@@ -107,28 +230,31 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		if json.Unmarshal(dataBytes, &initData) == nil {
 			if mode, ok := initData["mode"].(string); ok {
 				client.Mode = mode
-				log.Printf("[WORKER] Client initialized in %s mode", mode)
+				logger.Info("Client initialized", "mode", mode)
 			} else {
 				client.Mode = "PLAYBACK" // Safe default
-				log.Println("[WORKER] No mode specified, defaulting to PLAYBACK mode")
+				logger.Warn("No mode specified, defaulting to PLAYBACK mode")
 			}
 		} else {
 			client.Mode = "PLAYBACK" // Safe default
-			log.Println("[WORKER] Failed to parse init data, defaulting to PLAYBACK mode")
+			logger.Warn("Failed to parse init data, defaulting to PLAYBACK mode")
 		}
 
 		// Initialize Git repository based on mode
-		err := h.fsSvc.InitializeGit(client.Mode)
+		err := h.fsSvc.InitializeGit(ctx, client.Mode)
 		if err != nil {
-			log.Printf("[WORKER] Git initialization failed: %v", err)
+			logger.Error("Git initialization failed", "error", err)
 			ack.Error = err.Error()
 		}
 		// Note: No initial commit is created during init
 		// Commits will only be created when files are actually modified
 
-		log.Println("[WORKER] Bridge initialized.")
+		logger.Info("Bridge initialized")
 		go h.watchSvc.StartEventLoop(func(event fsnotify.Event) {
-			log.Printf("WORKER: Watch event detected %s", event.Op)
+			slog.Debug("Watch event detected", logging.KeyComponent, "watcher", "op", event.Op.String(), "path", event.Name)
+			if filesystem.IsStagingPath(event.Name) {
+				return // The explorer sees it once it is renamed into place.
+			}
 
 			// Convert absolute path to /workspace relative path
 			relPath := strings.TrimPrefix(event.Name, h.fsSvc.GetBaseDir())
@@ -170,13 +296,13 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		h.watchSvc.Watch("/workspace")
 		return
 	case "create-initial-commit":
-		log.Println("[WORKER] Creating initial commit for recording.")
+		logger.Info("Creating initial commit for recording")
 		// Only create initial commit in RECORDING mode
 		if client.Mode == "RECORDING" {
 			// Create a commit with the current workspace state
-			commitHash, err := h.fsSvc.CreateInitialCommit()
+			commitHash, err := h.fsSvc.CreateInitialCommit(ctx)
 			if err != nil {
-				log.Printf("[WORKER] Failed to create initial commit: %v", err)
+				logger.Error("Failed to create initial commit", "error", err)
 				ack.Error = err.Error()
 			} else if commitHash != "" {
 				// Broadcast workspace:commit event
@@ -187,20 +313,21 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 						"message": "Initial workspace snapshot",
 					},
 				})
-				log.Printf("[WORKER] Initial commit created and broadcast: %s", commitHash[:8])
+				logger.Info("Initial commit created and broadcast", "hash", commitHash[:8])
 				ack.Data = map[string]interface{}{
 					"ackID": reqAckID,
 					"hash":  commitHash,
 				}
 			} else {
-				log.Println("[WORKER] No changes to commit for initial snapshot")
+				logger.Info("No changes to commit for initial snapshot")
 			}
 		}
 	case "hydrate-create-file":
-		log.Println("[WORKER] Hydrating file.")
+		logger.Debug("Hydrating file")
+		h.setHydrationState(HydrationInProgress)
 		var req types.HydrateFileRequest
 		json.Unmarshal(dataBytes, &req)
-		commitHash, err := h.fsSvc.CreateFileBase64(req.TargetPath, req.ContentBase64)
+		commitHash, err := h.fsSvc.CreateFileBase64(ctx, req.TargetPath, req.ContentBase64)
 		if err != nil {
 			ack.Error = err.Error()
 		} else {
@@ -225,7 +352,7 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			}
 
 			// Read parent folder contents to return to frontend
-			folderContents, err := h.fsSvc.ReadFolder(parentPath)
+			folderContents, err := h.fsSvc.ReadFolder(ctx, parentPath)
 			if err != nil {
 				ack.Error = err.Error()
 			} else {
@@ -238,37 +365,89 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			}
 		}
 	case "crud-read-folder":
-		log.Println("[WORKER] Reading folder.")
+		logger.Debug("Reading folder")
 		var req types.FileRequest
 		json.Unmarshal(dataBytes, &req)
 		h.watchSvc.Watch(req.TargetPath)
-		entries, err := h.fsSvc.ReadFolder(req.TargetPath)
+		entries, err := h.fsSvc.ReadFolderTree(ctx, req.TargetPath, req.Depth)
 		if err != nil {
 			ack.Error = err.Error()
 		} else {
+			// Folders returned expanded are watched like the requested one, and
+			// collapsed the same way.
+			h.watchExpanded(entries)
 			ack.Data = map[string]interface{}{
 				"ackID":          reqAckID,
 				"targetPath":     req.TargetPath,
 				"folderContents": entries,
 			}
 		}
+	case "crud-stat":
+		logger.Debug("Stat resource")
+		var req types.FileRequest
+		json.Unmarshal(dataBytes, &req)
+		entry, err := h.fsSvc.Stat(ctx, req.TargetPath)
+		if err != nil {
+			ack.Error = err.Error()
+		} else {
+			ack.Data = map[string]interface{}{
+				"ackID":      reqAckID,
+				"targetPath": req.TargetPath,
+				"entry":      entry,
+			}
+		}
 	case "crud-collapse-folder":
 		var req types.FileRequest
 		json.Unmarshal(dataBytes, &req)
 		h.watchSvc.Unwatch(req.TargetPath)
 		return
 	case "crud-read-file":
-		log.Println("[WORKER] Reading file.")
+		logger.Debug("Reading file")
 		var req types.FileRequest
 		json.Unmarshal(dataBytes, &req)
-		content, err := h.fsSvc.ReadFile(req.TargetPath)
+		data, err := h.readFile(ctx, client, req, reqAckID)
 		if err != nil {
 			ack.Error = err.Error()
 		} else {
+			ack.Data = data
+		}
+	case "crud-write-chunk":
+		logger.Debug("Writing file chunk")
+		var req types.ChunkRequest
+		json.Unmarshal(dataBytes, &req)
+		data, err := base64.StdEncoding.DecodeString(req.Content)
+		var received int64
+		if req.Abort {
+			h.fsSvc.AbortUpload(req.TargetPath)
+		} else if err == nil {
+			received, err = h.fsSvc.WriteChunk(ctx, req.TargetPath, req.Offset, data)
+		}
+		var commitHash string
+		if err == nil && req.Final {
+			commitHash, err = h.fsSvc.FinishUpload(ctx, req.TargetPath, req.Checksum, req.ExpectedVersion)
+		}
+		var conflict *filesystem.VersionConflictError
+		if errors.As(err, &conflict) {
+			ack.Error = err.Error()
+			ack.Data = conflictData(reqAckID, req.TargetPath, conflict)
+		} else if err != nil {
+			ack.Error = err.Error()
+		} else {
+			if commitHash != "" {
+				client.hub.Send(&types.Message{
+					Event: "workspace:commit",
+					Data: map[string]interface{}{
+						"hash":    commitHash,
+						"message": "FS_UPLOAD_FILE: " + req.TargetPath,
+					},
+				})
+			}
 			ack.Data = map[string]interface{}{
-				"ackID":       reqAckID,
-				"targetPath":  req.TargetPath,
-				"fileContent": content,
+				"ackID":      reqAckID,
+				"targetPath": req.TargetPath,
+				"received":   received,
+				"done":       req.Final,
+				"aborted":    req.Abort,
 			}
 		}
 	case "crud-close-file":
@@ -277,11 +456,19 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		h.watchSvc.RemoveFileReference(req.TargetPath)
 		return
 	case "crud-update-file":
-		log.Println("[WORKER] Updating file.")
+		logger.Debug("Updating file")
 		var req types.FileRequest
 		json.Unmarshal(dataBytes, &req)
-		commitHash, err := h.fsSvc.UpdateFile(req.TargetPath, req.FileContent)
-		if err != nil {
+		content, err := filesystem.DecodeContent(req.FileContent, req.Encoding)
+		var commitHash string
+		if err == nil {
+			commitHash, err = h.fsSvc.UpdateFile(ctx, req.TargetPath, content, req.ExpectedVersion)
+		}
+		var conflict *filesystem.VersionConflictError
+		if errors.As(err, &conflict) {
+			ack.Error = err.Error()
+			ack.Data = conflictData(reqAckID, req.TargetPath, conflict)
+		} else if err != nil {
 			ack.Error = err.Error()
 		} else {
 			// Broadcast commit event if hash is non-empty (RECORDING mode)
@@ -298,13 +485,46 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 				"ackID":      reqAckID,
 				"targetPath": req.TargetPath,
 				"status":     "updated",
+				"version":    filesystem.ContentVersion([]byte(content)),
+			}
+		}
+	case "crud-patch-file":
+		logger.Debug("Patching file")
+		var req types.PatchRequest
+		json.Unmarshal(dataBytes, &req)
+		version, commitHash, err := h.fsSvc.PatchFile(ctx, req.TargetPath, req.ExpectedVersion, req.Edits)
+		var conflict *filesystem.VersionConflictError
+		if errors.As(err, &conflict) {
+			ack.Error = err.Error()
+			ack.Data = conflictData(reqAckID, req.TargetPath, conflict)
+		} else if err != nil {
+			ack.Error = err.Error()
+		} else {
+			if commitHash != "" {
+				client.hub.Send(&types.Message{
+					Event: "workspace:commit",
+					Data: map[string]interface{}{
+						"hash":    commitHash,
+						"message": "FS_PATCH_FILE: " + req.TargetPath,
+					},
+				})
+			}
+			ack.Data = map[string]interface{}{
+				"ackID":      reqAckID,
+				"targetPath": req.TargetPath,
+				"status":     "patched",
+				"version":    version,
 			}
 		}
 	case "crud-create-file":
-		log.Println("[WORKER] Creating file.")
+		logger.Debug("Creating file")
 		var req types.FileRequest
 		json.Unmarshal(dataBytes, &req)
-		commitHash, err := h.fsSvc.CreateFile(req.TargetPath, req.FileContent)
+		content, err := filesystem.DecodeContent(req.FileContent, req.Encoding)
+		var commitHash string
+		if err == nil {
+			commitHash, err = h.fsSvc.CreateFile(ctx, req.TargetPath, content)
+		}
 		if err != nil {
 			ack.Error = err.Error()
 		} else {
@@ -329,7 +549,7 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			}
 
 			// Read parent folder contents to return to frontend
-			folderContents, err := h.fsSvc.ReadFolder(parentPath)
+			folderContents, err := h.fsSvc.ReadFolder(ctx, parentPath)
 			if err != nil {
 				ack.Error = err.Error()
 			} else {
@@ -342,10 +562,10 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			}
 		}
 	case "crud-create-folder":
-		log.Println("[WORKER] Creating folder.")
+		logger.Debug("Creating folder")
 		var req types.FileRequest
 		json.Unmarshal(dataBytes, &req)
-		commitHash, err := h.fsSvc.CreateFolder(req.TargetPath)
+		commitHash, err := h.fsSvc.CreateFolder(ctx, req.TargetPath)
 		if err != nil {
 			ack.Error = err.Error()
 		} else {
@@ -370,7 +590,7 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			}
 
 			// Read parent folder contents to return to frontend
-			folderContents, err := h.fsSvc.ReadFolder(parentPath)
+			folderContents, err := h.fsSvc.ReadFolder(ctx, parentPath)
 			if err != nil {
 				ack.Error = err.Error()
 			} else {
@@ -383,10 +603,10 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			}
 		}
 	case "crud-delete-resource":
-		log.Println("[WORKER] Deleting resource.")
+		logger.Debug("Deleting resource")
 		var req types.FileRequest
 		json.Unmarshal(dataBytes, &req)
-		commitHash, err := h.fsSvc.DeleteResource(req.TargetPath)
+		deletion, commitHash, err := h.fsSvc.DeleteResource(ctx, req.TargetPath)
 		if err != nil {
 			ack.Error = err.Error()
 		} else {
@@ -404,13 +624,57 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 				"ackID":      reqAckID,
 				"targetPath": req.TargetPath,
 				"status":     "deleted",
+				"trashID":    deletion.TrashID,   // For crud-restore-resource
+				"permanent":  deletion.Permanent, // Too large for the trash
+			}
+		}
+	case "crud-list-trash":
+		logger.Debug("Listing trash")
+		entries, err := h.fsSvc.ListTrash(ctx)
+		if err != nil {
+			ack.Error = err.Error()
+		} else {
+			ack.Data = map[string]interface{}{
+				"ackID":   reqAckID,
+				"entries": entries,
+			}
+		}
+	case "crud-restore-resource":
+		logger.Debug("Restoring resource")
+		var req types.RestoreRequest
+		json.Unmarshal(dataBytes, &req)
+		restoredPath, commitHash, err := h.fsSvc.RestoreResource(ctx, req.ID)
+		if err != nil {
+			ack.Error = err.Error()
+		} else {
+			if commitHash != "" {
+				client.hub.Send(&types.Message{
+					Event: "workspace:commit",
+					Data: map[string]interface{}{
+						"hash":    commitHash,
+						"message": "FS_RESTORE_RESOURCE: " + restoredPath,
+					},
+				})
+			}
+			parentPath := path.Dir(restoredPath)
+			folderContents, err := h.fsSvc.ReadFolder(ctx, parentPath)
+			if err != nil {
+				ack.Error = err.Error()
+			} else {
+				ack.Data = map[string]interface{}{
+					"ackID":          reqAckID,
+					"id":             req.ID,
+					"targetPath":     parentPath,
+					"folderContents": folderContents,
+					"restoredPath":   restoredPath,
+				}
 			}
 		}
 	case "crud-move-resource":
-		log.Println("[WORKER] Moving resource.")
+		logger.Debug("Moving resource")
 		var req types.MoveRequest
 		json.Unmarshal(dataBytes, &req)
-		commitHash, err := h.fsSvc.MoveResource(req.TargetPath, req.NewPath)
+		commitHash, err := h.fsSvc.MoveResource(ctx, req.TargetPath, req.NewPath)
 		if err != nil {
 			ack.Error = err.Error()
 		} else {
@@ -435,7 +699,7 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			}
 
 			// Read parent folder contents to return to frontend
-			folderContents, err := h.fsSvc.ReadFolder(parentPath)
+			folderContents, err := h.fsSvc.ReadFolder(ctx, parentPath)
 			if err != nil {
 				ack.Error = err.Error()
 			} else {
@@ -448,38 +712,351 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 				}
 			}
 		}
+	case "crud-copy-resource":
+		logger.Debug("Copying resource")
+		var req types.MoveRequest
+		json.Unmarshal(dataBytes, &req)
+		// An empty newPath duplicates the resource beside itself.
+		newPath, commitHash, err := h.fsSvc.CopyResource(ctx, req.TargetPath, req.NewPath)
+		if err != nil {
+			ack.Error = err.Error()
+		} else {
+			if commitHash != "" {
+				client.hub.Send(&types.Message{
+					Event: "workspace:commit",
+					Data: map[string]interface{}{
+						"hash":    commitHash,
+						"message": filesystem.CopyCommitMessage(req.TargetPath, newPath),
+					},
+				})
+			}
+			parentPath := path.Dir(newPath)
+			folderContents, err := h.fsSvc.ReadFolder(ctx, parentPath)
+			if err != nil {
+				ack.Error = err.Error()
+			} else {
+				ack.Data = map[string]interface{}{
+					"ackID":          reqAckID,
+					"targetPath":     parentPath,
+					"folderContents": folderContents,
+					"oldPath":        req.TargetPath,
+					"newPath":        newPath,
+				}
+			}
+		}
 	case "create-terminal":
-		log.Println("[WORKER] Creating terminal.")
+		logger.Debug("Creating terminal")
 		var req types.TerminalRequest
 		json.Unmarshal(dataBytes, &req)
-		_, err := h.termSvc.CreateOrGetTerminal(req.ID, func(data []byte) {
-			client.hub.Send(&types.Message{
-				Event: "terminal-data",
-				Data:  map[string]interface{}{"id": req.ID, "content": string(data)},
-			})
-		})
+		opts, err := h.terminalOptions(req)
+		terminalID := req.ID
+		if err == nil {
+			terminalID, err = h.termSvc.CreateOrGetTerminal(req.ID, opts, h.terminalOutput(client, req.ID))
+		}
 		if err != nil {
+			logger.Warn("Failed to create terminal", "terminalId", req.ID, "error", err)
 			ack.Error = err.Error()
+		} else if cols, rows, ok := h.termSvc.TerminalSize(terminalID); ok {
+			ack.Data = map[string]interface{}{
+				"ackID": reqAckID,
+				"id":    terminalID,
+				"cols":  cols,
+				"rows":  rows,
+			}
+			h.broadcastResize(client, terminalID, cols, rows)
 		}
 	case "terminal-input":
-		log.Println("[WORKER] Inputing terminal.")
+		logger.Debug("Writing terminal input")
 		var req types.TerminalInput
 		json.Unmarshal(dataBytes, &req)
 		h.termSvc.WriteToTerminal(req)
 		return
+	case "attach-terminal":
+		logger.Debug("Attaching terminal")
+		var req types.TerminalRequest
+		json.Unmarshal(dataBytes, &req)
+		if req.Cols != 0 && req.Rows != 0 {
+			if err := h.termSvc.ResizeTerminal(types.TerminalResize{ID: req.ID, Cols: req.Cols, Rows: req.Rows}); err != nil {
+				logger.Warn("Failed to resize terminal on attach", "terminalId", req.ID, "error", err)
+			} else {
+				h.broadcastResize(client, req.ID, req.Cols, req.Rows)
+			}
+		}
+		scrollback, err := h.termSvc.AttachTerminal(req.ID, h.terminalOutput(client, req.ID))
+		if err != nil {
+			ack.Error = err.Error()
+		} else {
+			cols, rows, _ := h.termSvc.TerminalSize(req.ID)
+			ack.Data = map[string]interface{}{
+				"ackID":      reqAckID,
+				"id":         req.ID,
+				"scrollback": string(scrollback),
+				"cols":       cols,
+				"rows":       rows,
+			}
+			logger.Info("Terminal attached", "terminalId", req.ID, "scrollbackBytes", len(scrollback))
+		}
+	case "terminal-signal":
+		logger.Debug("Signalling terminal")
+		var req types.TerminalSignal
+		json.Unmarshal(dataBytes, &req)
+		if err := h.termSvc.SignalTerminal(req); err != nil {
+			logger.Warn("Failed to signal terminal", "terminalId", req.ID, "signal", req.Signal, "error", err)
+			ack.Error = err.Error()
+		}
+	case "terminal-resize":
+		logger.Debug("Resizing terminal")
+		var req types.TerminalResize
+		json.Unmarshal(dataBytes, &req)
+		if err := h.termSvc.ResizeTerminal(req); err != nil {
+			logger.Warn("Failed to resize terminal", "terminalId", req.ID, "error", err)
+			ack.Error = err.Error()
+		} else {
+			h.broadcastResize(client, req.ID, req.Cols, req.Rows)
+		}
 	case "close-terminal":
-		log.Println("[WORKER] Closing terminal.")
+		logger.Debug("Closing terminal")
 		var req types.TerminalRequest
 		json.Unmarshal(dataBytes, &req)
 		h.termSvc.CloseTerminal(req.ID)
+	case "list-terminals":
+		logger.Debug("Listing terminals")
+		ack.Data = map[string]interface{}{
+			"ackID":     reqAckID,
+			"terminals": h.termSvc.ListTerminals(),
+		}
+	case "replay-terminal":
+		logger.Debug("Replaying terminal")
+		var req types.ReplayRequest
+		json.Unmarshal(dataBytes, &req)
+		replayID := req.ID
+		if replayID == "" {
+			replayID = uuid.New().String()
+		}
+		rec, err := h.replaySource(req)
+		var opts terminal.Options
+		if err == nil && req.Mode == terminal.ReplayInput {
+			opts, err = h.terminalOptions(types.TerminalRequest{Shell: req.Shell, Cwd: req.Cwd, Env: req.Env})
+		}
+		if err == nil {
+			speed := 1.0
+			if req.Speed != nil {
+				speed = *req.Speed
+			}
+			_, err = h.termSvc.Replay(replayID, rec, terminal.ReplayOptions{Mode: req.Mode, Speed: speed, Until: req.Until}, opts, terminal.ReplayCallbacks{
+				Output: h.terminalOutput(client, replayID),
+				Resize: func(cols, rows uint16) { h.broadcastResize(client, replayID, cols, rows) },
+				Done: func(result terminal.ReplayResult) {
+					done := map[string]interface{}{
+						"id":        replayID,
+						"mode":      req.Mode,
+						"played":    result.Played,
+						"cancelled": result.Cancelled,
+					}
+					if result.Err != nil {
+						done["error"] = result.Err.Error()
+					}
+					client.hub.Send(&types.Message{Event: terminal.EventReplayDone, Data: done})
+				},
+			})
+		}
+		if err != nil {
+			logger.Warn("Failed to replay terminal", "terminalId", replayID, "mode", req.Mode, "error", err)
+			ack.Error = err.Error()
+		} else {
+			if cols, rows, ok := h.termSvc.TerminalSize(replayID); ok {
+				h.broadcastResize(client, replayID, cols, rows)
+			}
+			ack.Data = map[string]interface{}{
+				"ackID":  reqAckID,
+				"id":     replayID,
+				"mode":   req.Mode,
+				"events": len(rec.Events),
+				"cols":   rec.Cols,
+				"rows":   rec.Rows,
+			}
+		}
+	case "cancel-replay":
+		logger.Debug("Cancelling terminal replay")
+		var req types.TerminalRequest
+		json.Unmarshal(dataBytes, &req)
+		ack.Data = map[string]interface{}{
+			"ackID":     reqAckID,
+			"id":        req.ID,
+			"cancelled": h.termSvc.CancelReplay(req.ID),
+		}
+	case "exec":
+		logger.Debug("Executing command")
+		var req types.ExecRequest
+		json.Unmarshal(dataBytes, &req)
+		execID := req.ID
+		if execID == "" {
+			execID = uuid.New().String()
+		}
+		opts, err := h.execOptions(req)
+		var result terminal.ExecResult
+		if err == nil {
+			// The exec stops early if its frontend client or the bridge leaves.
+			execCtx, done := h.startExec(ctx, client, searchKey(msg, execID))
+			defer done()
+			// Output goes only to the requester, tagged with the request's
+			// ackID, on the same channel as the ack, which must come last.
+			result, err = h.termSvc.Exec(execCtx, opts, func(stream string, data []byte) {
+				client.Send <- &types.Message{
+					Event: "exec-output",
+					Data: map[string]interface{}{
+						"ackID":   reqAckID,
+						"id":      execID,
+						"stream":  stream,
+						"content": string(data),
+					},
+				}
+			})
+		}
+		if err != nil {
+			logger.Warn("Failed to execute command", "command", req.Command, "error", err)
+			ack.Error = err.Error()
+		} else {
+			ack.Data = map[string]interface{}{
+				"ackID":      reqAckID,
+				"id":         execID,
+				"code":       result.Code,
+				"signal":     result.Signal,
+				"durationMs": result.Duration.Milliseconds(),
+				"timedOut":   result.TimedOut,
+			}
+		}
+	case "crud-search":
+		logger.Debug("Searching files")
+		var req types.SearchRequest
+		json.Unmarshal(dataBytes, &req)
+		searchCtx, done := h.startSearch(ctx, searchKey(msg, req.ID))
+		// Matches go only to the requester, ahead of the ack like exec output.
+		result, err := h.fsSvc.Search(searchCtx, filesystem.SearchOptions{
+			Query:         req.Query,
+			Regex:         req.Regex,
+			CaseSensitive: req.CaseSensitive,
+			Include:       req.Include,
+			Exclude:       req.Exclude,
+			MaxResults:    req.MaxResults,
+			TargetPath:    req.TargetPath,
+		}, func(matches []types.SearchMatch) {
+			client.Send <- &types.Message{
+				Event: "search-results",
+				Data: map[string]interface{}{
+					"ackID":   reqAckID,
+					"id":      req.ID,
+					"matches": matches,
+				},
+			}
+		})
+		done()
+		cancelled := errors.Is(err, context.Canceled)
+		if err != nil && !cancelled {
+			logger.Warn("Search failed", "query", req.Query, "error", err)
+			ack.Error = err.Error()
+		} else {
+			ack.Data = map[string]interface{}{
+				"ackID":     reqAckID,
+				"id":        req.ID,
+				"matches":   result.Matches,
+				"files":     result.Files,
+				"searched":  result.Searched,
+				"truncated": result.Truncated,
+				"cancelled": cancelled,
+			}
+		}
+	case "crud-find-files":
+		logger.Debug("Finding files")
+		var req struct {
+			Query string `json:"query"`
+			Limit int    `json:"limit"`
+		}
+		json.Unmarshal(dataBytes, &req)
+		files, complete := h.index.Find(req.Query, req.Limit)
+		ack.Data = map[string]interface{}{
+			"ackID":    reqAckID,
+			"query":    req.Query,
+			"files":    files,
+			"complete": complete,
+		}
+	case "crud-replace":
+		var req types.ReplaceRequest
+		json.Unmarshal(dataBytes, &req)
+		opts := filesystem.ReplaceOptions{
+			SearchOptions: filesystem.SearchOptions{
+				Query:         req.Query,
+				Regex:         req.Regex,
+				CaseSensitive: req.CaseSensitive,
+				Include:       req.Include,
+				Exclude:       req.Exclude,
+				TargetPath:    req.TargetPath,
+			},
+			Replacement: req.Replacement,
+		}
+		if !req.Apply {
+			logger.Debug("Previewing replace")
+			files, err := h.fsSvc.PreviewReplace(ctx, opts)
+			if err != nil {
+				ack.Error = err.Error()
+			} else {
+				ack.Data = map[string]interface{}{
+					"ackID": reqAckID,
+					"apply": false,
+					"files": files,
+				}
+			}
+		} else {
+			logger.Debug("Applying replace")
+			files, commitHash, err := h.fsSvc.Replace(ctx, opts, req.Files)
+			if err != nil {
+				logger.Warn("Replace failed", "query", req.Query, "error", err)
+				ack.Error = err.Error()
+			} else {
+				// One commit for the whole replace, however many files it touched.
+				if commitHash != "" {
+					client.hub.Send(&types.Message{
+						Event: "workspace:commit",
+						Data: map[string]interface{}{
+							"hash":    commitHash,
+							"message": filesystem.ReplaceCommitMessage(opts, len(files)),
+						},
+					})
+				}
+				ack.Data = map[string]interface{}{
+					"ackID":      reqAckID,
+					"apply":      true,
+					"files":      files,
+					"commitHash": commitHash,
+				}
+			}
+		}
+	case "cancel-search":
+		logger.Debug("Cancelling search")
+		var req types.SearchRequest
+		json.Unmarshal(dataBytes, &req)
+		ack.Data = map[string]interface{}{
+			"ackID":     reqAckID,
+			"id":        req.ID,
+			"cancelled": h.cancelSearch(searchKey(msg, req.ID)),
+		}
+	case "client-disconnected":
+		// The bridge reports that a frontend client left; nobody is waiting
+		// for its execs and searches any more.
+		if msg.Meta != nil && msg.Meta.ClientID != "" {
+			if n := h.cancelClient(msg.Meta.ClientID); n > 0 {
+				logger.Info("Cancelled commands of disconnected client", "commands", n)
+			}
+		}
+		return
 	case "watch":
-		log.Println("[WORKER] Watching path.")
+		logger.Debug("Watching path")
 		var req types.FileRequest
 		json.Unmarshal(dataBytes, &req)
 		h.watchSvc.Watch(req.TargetPath)
 		return
 	case "command-preview":
-		log.Println("[WORKER] Preview command.")
+		logger.Debug("Preview command")
 		var req struct {
 			AckID string `json:"ackID"`
 		}
@@ -488,7 +1065,7 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		// Load configuration from config.toml
 		cfg, err := config.LoadConfig(h.fsSvc.GetBaseDir())
 		if err != nil {
-			log.Printf("[WORKER] Failed to load config: %v", err)
+			logger.Error("Failed to load config", "error", err)
 			client.Send <- &types.Message{
 				Event: "command-result-preview",
 				Data: map[string]interface{}{
@@ -503,9 +1080,21 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		go func(c *Client, ackID string, previewCfg config.PreviewConfig) {
 			// Create terminal - use pointer to capture terminal ID for callback
 			terminalIDPtr := new(string)
-			terminalID, err := h.termSvc.CreateOrGetTerminal("", func(data []byte) {
+			opts, err := h.terminalOptions(types.TerminalRequest{})
+			if err != nil {
+				logger.Error("Failed to resolve preview terminal options", "error", err)
+				c.Send <- &types.Message{
+					Event: "command-result-preview",
+					Data: map[string]interface{}{
+						"ackID": ackID,
+						"error": err.Error(),
+					},
+				}
+				return
+			}
+			terminalID, err := h.termSvc.CreateOrGetTerminal("", opts, func(data []byte) {
 				// Forward terminal output to client
-				log.Printf("[WORKER] Sending terminal-data event: terminalId=%s, contentLength=%d", *terminalIDPtr, len(data))
+				slog.Debug("Sending terminal-data event", logging.KeyEvent, "terminal-data", "terminalId", *terminalIDPtr, "bytes", len(data))
 				c.hub.Send(&types.Message{
 					Event: "terminal-data",
 					Data:  map[string]interface{}{"id": *terminalIDPtr, "content": string(data)},
@@ -514,8 +1103,7 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			*terminalIDPtr = terminalID
 
 			if err != nil {
-				log.Printf("[WORKER] Failed to create preview terminal: %v", err)
-				log.Printf("[WORKER] Sending command-result-preview event (error): ackID=%s, error=%s", ackID, err.Error())
+				logger.Error("Failed to create preview terminal", "error", err)
 				c.Send <- &types.Message{
 					Event: "command-result-preview",
 					Data: map[string]interface{}{
@@ -527,7 +1115,7 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			}
 
 			// Send initial response with preview info and terminal ID
-			log.Printf("[WORKER] Sending command-result-preview event: ackID=%s, terminalId=%s, command=%s, url=%s", ackID, terminalID, previewCfg.Command, previewCfg.URL)
+			logger.Info("Sending command-result-preview event", "terminalId", terminalID, "command", previewCfg.Command, "url", previewCfg.URL)
 			c.Send <- &types.Message{
 				Event: "command-result-preview",
 				Data: map[string]interface{}{
@@ -546,12 +1134,12 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			})
 
 			if err != nil {
-				log.Printf("[WORKER] Failed to execute preview command: %v", err)
+				logger.Error("Failed to execute preview command", "error", err)
 			}
 		}(client, req.AckID, cfg.Preview)
 		return
 	case "command-run":
-		log.Println("[WORKER] Run command.")
+		logger.Debug("Run command")
 		var req struct {
 			AckID string `json:"ackID"`
 		}
@@ -560,7 +1148,7 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		// Load configuration from config.toml
 		cfg, err := config.LoadConfig(h.fsSvc.GetBaseDir())
 		if err != nil {
-			log.Printf("[WORKER] Failed to load config: %v", err)
+			logger.Error("Failed to load config", "error", err)
 			client.Send <- &types.Message{
 				Event: "command-result-run",
 				Data: map[string]interface{}{
@@ -575,8 +1163,22 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		go func(c *Client, ackID string, runCfg config.RunConfig) {
 			// Create terminal - use pointer to capture terminal ID for callback
 			terminalIDPtr := new(string)
-			terminalID, err := h.termSvc.CreateOrGetTerminal("", func(data []byte) {
-				log.Printf("[WORKER] Sending terminal-data event: terminalId=%s, contentLength=%d", *terminalIDPtr, len(data))
+			opts, err := h.terminalOptions(types.TerminalRequest{})
+			if err != nil {
+				logger.Error("Failed to resolve run terminal options", "error", err)
+				if ackID != "" {
+					c.Send <- &types.Message{
+						Event: "command-result-run",
+						Data: map[string]interface{}{
+							"ackID": ackID,
+							"error": err.Error(),
+						},
+					}
+				}
+				return
+			}
+			terminalID, err := h.termSvc.CreateOrGetTerminal("", opts, func(data []byte) {
+				slog.Debug("Sending terminal-data event", logging.KeyEvent, "terminal-data", "terminalId", *terminalIDPtr, "bytes", len(data))
 				c.hub.Send(&types.Message{
 					Event: "terminal-data",
 					Data:  map[string]interface{}{"id": *terminalIDPtr, "content": string(data)},
@@ -585,9 +1187,8 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			*terminalIDPtr = terminalID
 
 			if err != nil {
-				log.Printf("[WORKER] Failed to create run terminal: %v", err)
+				logger.Error("Failed to create run terminal", "error", err)
 				if ackID != "" {
-					log.Printf("[WORKER] Sending command-result-run event (error): ackID=%s, error=%s", ackID, err.Error())
 					c.Send <- &types.Message{
 						Event: "command-result-run",
 						Data: map[string]interface{}{
@@ -601,7 +1202,7 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 
 			// Send acknowledgment with terminal ID
 			if ackID != "" {
-				log.Printf("[WORKER] Sending command-result-run event: ackID=%s, terminalId=%s, command=%s, status=executed", ackID, terminalID, runCfg.Command)
+				logger.Info("Sending command-result-run event", "terminalId", terminalID, "command", runCfg.Command, "status", "executed")
 				c.Send <- &types.Message{
 					Event: "command-result-run",
 					Data: map[string]interface{}{
@@ -620,12 +1221,12 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			})
 
 			if err != nil {
-				log.Printf("[WORKER] Failed to execute run command: %v", err)
+				logger.Error("Failed to execute run command", "error", err)
 			}
 		}(client, req.AckID, cfg.Run)
 		return
 	case "crud-download-workspace":
-		log.Println("[WORKER] Download workspace.")
+		logger.Debug("Download workspace")
 		var req struct {
 			AckID string `json:"ackID"`
 		}
@@ -641,7 +1242,7 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		}
 		return
 	case "system:checkout":
-		log.Println("[WORKER] Git checkout command.")
+		logger.Debug("Git checkout command")
 		var req struct {
 			Hash  string `json:"hash"`
 			AckID string `json:"ackID"`
@@ -651,21 +1252,21 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		if req.Hash == "" {
 			ack.Error = "commit hash is required"
 		} else {
-			err := h.fsSvc.CheckoutCommit(req.Hash)
+			err := h.fsSvc.CheckoutCommit(ctx, req.Hash)
 			if err != nil {
 				ack.Error = err.Error()
-				log.Printf("[WORKER] Git checkout failed: %v", err)
+				logger.Error("Git checkout failed", "error", err)
 			} else {
 				ack.Data = map[string]interface{}{
 					"ackID":  reqAckID,
 					"hash":   req.Hash,
 					"status": "checked-out",
 				}
-				log.Printf("[WORKER] ✅ Successfully checked out commit: %s", req.Hash[:8])
+				logger.Info("Checked out commit", "hash", req.Hash)
 			}
 		}
 	case "system:create-branch":
-		log.Println("[WORKER] Git create branch command.")
+		logger.Debug("Git create branch command")
 		var req struct {
 			CommitHash string `json:"commitHash"`
 			BranchName string `json:"branchName"`
@@ -678,10 +1279,10 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		} else if req.BranchName == "" {
 			ack.Error = "branch name is required"
 		} else {
-			err := h.fsSvc.CreateBranchAndCheckout(req.CommitHash, req.BranchName)
+			err := h.fsSvc.CreateBranchAndCheckout(ctx, req.CommitHash, req.BranchName)
 			if err != nil {
 				ack.Error = err.Error()
-				log.Printf("[WORKER] Git create branch failed: %v", err)
+				logger.Error("Git create branch failed", "error", err)
 			} else {
 				ack.Data = map[string]interface{}{
 					"ackID":      reqAckID,
@@ -689,11 +1290,11 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 					"branchName": req.BranchName,
 					"status":     "created",
 				}
-				log.Printf("[WORKER] ✅ Successfully created and checked out branch: %s", req.BranchName)
+				logger.Info("Created and checked out branch", "branch", req.BranchName)
 			}
 		}
 	case "system:commit":
-		log.Println("[WORKER] Git commit command.")
+		logger.Debug("Git commit command")
 		var req struct {
 			Message string `json:"message"`
 			AckID   string `json:"ackID"`
@@ -705,27 +1306,27 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 			commitMessage = "Interactive changes"
 		}
 
-		commitHash, err := h.fsSvc.CommitChanges(commitMessage, client.Mode)
+		commitHash, err := h.fsSvc.CommitChanges(ctx, commitMessage, client.Mode)
 		if err != nil {
 			ack.Error = err.Error()
-			log.Printf("[WORKER] Git commit failed: %v", err)
+			logger.Error("Git commit failed", "error", err)
 		} else if commitHash != "" {
 			ack.Data = map[string]interface{}{
 				"ackID":      reqAckID,
 				"commitHash": commitHash,
 				"status":     "committed",
 			}
-			log.Printf("[WORKER] ✅ Successfully committed changes: %s", commitHash[:8])
+			logger.Info("Committed changes", "hash", commitHash[:8])
 		} else {
 			// No changes to commit
 			ack.Data = map[string]interface{}{
 				"ackID":  reqAckID,
 				"status": "no-changes",
 			}
-			log.Println("[WORKER] No changes to commit")
+			logger.Info("No changes to commit")
 		}
 	case "system:save-branch":
-		log.Println("[WORKER] Git save branch command.")
+		logger.Debug("Git save branch command")
 		var req struct {
 			Timestamp int    `json:"timestamp"` // in seconds
 			AckID     string `json:"ackID"`
@@ -735,10 +1336,10 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		if req.Timestamp == 0 {
 			ack.Error = "timestamp is required"
 		} else {
-			branchName, commitHash, err := h.fsSvc.SaveBranch(req.Timestamp, client.Mode)
+			branchName, commitHash, err := h.fsSvc.SaveBranch(ctx, req.Timestamp, client.Mode)
 			if err != nil {
 				ack.Error = err.Error()
-				log.Printf("[WORKER] Git save branch failed: %v", err)
+				logger.Error("Git save branch failed", "error", err)
 			} else {
 				ack.Data = map[string]interface{}{
 					"ackID":      reqAckID,
@@ -746,18 +1347,19 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 					"commitHash": commitHash,
 					"status":     "saved",
 				}
-				log.Printf("[WORKER] ✅ Successfully saved branch: %s (%s)", branchName, commitHash[:8])
+				logger.Info("Saved branch", "branch", branchName, "hash", commitHash[:8])
 			}
 		}
 	case "hydration-complete":
-		log.Println("[WORKER] Workspace hydration complete, forwarding to frontend.")
+		logger.Info("Workspace hydration complete, forwarding to frontend")
+		h.setHydrationState(HydrationComplete)
 		// Forward hydration-complete event to frontend
 		client.Send <- &types.Message{
 			Event: "hydration-complete",
 			Data:  map[string]interface{}{},
 		}
 	default:
-		log.Printf("[WORKER] Unknown event type: %s", msg.Event)
+		logger.Warn("Unknown event type")
 		return
 	}
 
@@ -765,6 +1367,7 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		responseMsg := &types.Message{
 			Event: ack.Event,
 			Data:  ack.Data,
+			Meta:  msg.Meta,
 		}
 		if ack.Error != "" {
 			if dataMap, ok := responseMsg.Data.(map[string]interface{}); ok {
@@ -775,3 +1378,287 @@ func (h *Handler) routeMessage(client *Client, msg types.Message) {
 		client.Send <- responseMsg
 	}
 }
+
+// terminalConfig reads config.toml for the terminal defaults. A config.toml
+// that does not decode must not stop terminals from opening, so it is logged
+// and the built-in defaults are used instead.
+func (h *Handler) terminalConfig() *config.Config {
+	cfg, err := config.ReadConfig(h.fsSvc.GetBaseDir())
+	if err != nil {
+		slog.Warn("Using default terminal settings, config.toml is invalid", "error", err)
+		return config.DefaultConfig()
+	}
+	return cfg
+}
+
+// terminalOptions merges a terminal request over the config.toml defaults and
+// confines its working directory to the workspace.
+func (h *Handler) terminalOptions(req types.TerminalRequest) (terminal.Options, error) {
+	cfg := h.terminalConfig()
+
+	opts := terminal.Options{
+		Shell: cfg.Terminal.Shell,
+		Env:   make(map[string]string, len(cfg.Terminal.Env)+len(req.Env)),
+		Cols:  req.Cols,
+		Rows:  req.Rows,
+	}
+	if req.Shell != "" {
+		opts.Shell = req.Shell
+	}
+	for key, value := range cfg.Terminal.Env {
+		opts.Env[key] = value
+	}
+	for key, value := range req.Env {
+		opts.Env[key] = value
+	}
+
+	cwd := req.Cwd
+	if cwd == "" {
+		cwd = cfg.Terminal.Cwd
+	}
+	if cwd != "" {
+		var err error
+		if opts.Cwd, err = h.fsSvc.SecurePath(cwd); err != nil {
+			return terminal.Options{}, err
+		}
+	}
+	return opts, nil
+}
+
+// execOptions applies the config.toml terminal environment to an exec
+// request and confines its working directory to the workspace.
+func (h *Handler) execOptions(req types.ExecRequest) (terminal.ExecOptions, error) {
+	cfg := h.terminalConfig()
+
+	opts := terminal.ExecOptions{
+		Command: req.Command,
+		Args:    req.Args,
+		Env:     make(map[string]string, len(cfg.Terminal.Env)+len(req.Env)),
+		Timeout: time.Duration(req.TimeoutMs) * time.Millisecond,
+	}
+	for key, value := range cfg.Terminal.Env {
+		opts.Env[key] = value
+	}
+	for key, value := range req.Env {
+		opts.Env[key] = value
+	}
+	if req.Cwd != "" {
+		var err error
+		if opts.Cwd, err = h.fsSvc.SecurePath(req.Cwd); err != nil {
+			return terminal.ExecOptions{}, err
+		}
+	}
+	return opts, nil
+}
+
+// replaySource loads the recording a replay request refers to.
+func (h *Handler) replaySource(req types.ReplayRequest) (*terminal.Recording, error) {
+	switch {
+	case req.Recording != "" && len(req.Events) > 0:
+		return nil, errors.New("send either recording or events, not both")
+	case req.Recording != "":
+		return h.termSvc.OpenRecording(req.Recording)
+	case len(req.Events) > 0:
+		rec := &terminal.Recording{Cols: req.Cols, Rows: req.Rows}
+		if err := json.Unmarshal(req.Events, &rec.Events); err != nil {
+			return nil, fmt.Errorf("invalid replay events: %w", err)
+		}
+		return rec, nil
+	default:
+		return nil, errors.New("recording or events is required")
+	}
+}
+
+// readFile reads a file for crud-read-file: a range of it, the whole file
+// inline, or, when it is too large for that and the request allows, the whole
+// file as file-chunk events sent ahead of the ack.
+func (h *Handler) readFile(ctx context.Context, client *Client, req types.FileRequest, ackID string) (map[string]interface{}, error) {
+	if req.Length > 0 {
+		fileRange, err := h.fsSvc.ReadRange(ctx, req.TargetPath, req.Offset, req.Length)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"ackID":       ackID,
+			"targetPath":  req.TargetPath,
+			"fileContent": fileRange.Content,
+			"encoding":    fileRange.Encoding,
+			"offset":      fileRange.Offset,
+			"length":      fileRange.Length,
+			"size":        fileRange.Size,
+			"eof":         fileRange.EOF,
+		}, nil
+	}
+
+	file, err := h.fsSvc.ReadFileEncoded(ctx, req.TargetPath)
+	var tooLarge *filesystem.FileTooLargeError
+	if !errors.As(err, &tooLarge) || !req.Stream {
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"ackID":       ackID,
+			"targetPath":  req.TargetPath,
+			"fileContent": file.Content,
+			"encoding":    file.Encoding,
+			"mimeType":    file.MimeType,
+			"size":        file.Size,
+			"version":     file.Version,
+		}, nil
+	}
+
+	// Chunks go only to the requester, ahead of the ack like exec output.
+	chunks := 0
+	size, checksum, err := h.fsSvc.StreamFile(ctx, req.TargetPath, func(offset int64, data []byte) {
+		chunks++
+		client.Send <- &types.Message{
+			Event: "file-chunk",
+			Data: map[string]interface{}{
+				"ackID":      ackID,
+				"targetPath": req.TargetPath,
+				"offset":     offset,
+				"content":    base64.StdEncoding.EncodeToString(data),
+			},
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"ackID":      ackID,
+		"targetPath": req.TargetPath,
+		"streamed":   true,
+		"encoding":   filesystem.EncodingBase64,
+		"chunks":     chunks,
+		"size":       size,
+		"checksum":   checksum,
+		"version":    checksum, // A version is the SHA-256 of the content too.
+	}, nil
+}
+
+// conflictData is the ack of a write rejected for a stale version. The
+// current content goes back with it so the editor can merge.
+func conflictData(ackID, targetPath string, conflict *filesystem.VersionConflictError) map[string]interface{} {
+	return map[string]interface{}{
+		"ackID":       ackID,
+		"targetPath":  targetPath,
+		"conflict":    true,
+		"fileContent": conflict.Current.Content,
+		"encoding":    conflict.Current.Encoding,
+		"mimeType":    conflict.Current.MimeType,
+		"size":        conflict.Current.Size,
+		"version":     conflict.Current.Version,
+	}
+}
+
+// watchExpanded watches every folder in entries that was read with its
+// children.
+func (h *Handler) watchExpanded(entries []types.DirectoryEntry) {
+	for _, entry := range entries {
+		if entry.Children != nil {
+			h.watchSvc.Watch(entry.Path)
+			h.watchExpanded(entry.Children)
+		}
+	}
+}
+
+// searchKey identifies a search or exec by the client that started it and its
+// ID, so a client's new query replaces its previous one without touching
+// others, and a client's commands can be found when it leaves.
+func searchKey(msg types.Message, id string) string {
+	clientID := ""
+	if msg.Meta != nil {
+		clientID = msg.Meta.ClientID
+	}
+	return clientID + "/" + id
+}
+
+// startSearch registers a search under key, cancelling the one it replaces.
+// The returned func must be called when the search ends.
+func (h *Handler) startSearch(ctx context.Context, key string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &search{cancel: cancel}
+	h.searchMu.Lock()
+	if prev := h.searches[key]; prev != nil {
+		prev.cancel()
+	}
+	h.searches[key] = s
+	h.searchMu.Unlock()
+	return ctx, func() {
+		cancel()
+		h.searchMu.Lock()
+		if h.searches[key] == s {
+			delete(h.searches, key)
+		}
+		h.searchMu.Unlock()
+	}
+}
+
+// cancelSearch stops the search registered under key, if it is still running.
+func (h *Handler) cancelSearch(key string) bool {
+	h.searchMu.Lock()
+	s := h.searches[key]
+	h.searchMu.Unlock()
+	if s != nil {
+		s.cancel()
+	}
+	return s != nil
+}
+
+// startExec registers an exec under key, so that cancelClient can stop it, and
+// ties it to the bridge connection. The returned func must be called when the
+// exec ends.
+func (h *Handler) startExec(ctx context.Context, client *Client, key string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(client.ctx, cancel)
+	s := &search{cancel: cancel}
+	h.searchMu.Lock()
+	h.execs[key] = s
+	h.searchMu.Unlock()
+	return ctx, func() {
+		stop()
+		cancel()
+		h.searchMu.Lock()
+		if h.execs[key] == s {
+			delete(h.execs, key)
+		}
+		h.searchMu.Unlock()
+	}
+}
+
+// cancelClient stops the searches and execs started by the client with the
+// given ID and returns how many it stopped.
+func (h *Handler) cancelClient(clientID string) int {
+	prefix := clientID + "/"
+	var cancelled int
+	h.searchMu.Lock()
+	defer h.searchMu.Unlock()
+	for _, running := range []map[string]*search{h.searches, h.execs} {
+		for key, s := range running {
+			if strings.HasPrefix(key, prefix) {
+				s.cancel()
+				cancelled++
+			}
+		}
+	}
+	return cancelled
+}
+
+// terminalOutput returns the callback that forwards a terminal's output to the frontend.
+func (h *Handler) terminalOutput(client *Client, id string) func(data []byte) {
+	return func(data []byte) {
+		client.hub.Send(&types.Message{
+			Event: "terminal-data",
+			Data:  map[string]interface{}{"id": id, "content": string(data)},
+		})
+	}
+}
+
+// broadcastResize announces a terminal's window size so that recordings
+// capture it and playback can size its terminal to match.
+func (h *Handler) broadcastResize(client *Client, id string, cols, rows uint16) {
+	client.hub.Send(&types.Message{
+		Event: "terminal-resized",
+		Data:  map[string]interface{}{"id": id, "cols": cols, "rows": rows},
+	})
+}
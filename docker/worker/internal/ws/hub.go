@@ -1,10 +1,13 @@
 package ws
 
 import (
-	"log"
+	"log/slog"
+	"worker/internal/metrics"
 	"worker/pkg/types"
 )
 
+var bridgeConnected = metrics.NewGauge("worker_bridge_connected", "Whether a bridge is currently connected to the worker (1) or not (0).")
+
 type Hub struct {
 	Client     *Client
 	Register   chan *Client
@@ -24,16 +27,18 @@ func (h *Hub) Run() {
 		case client := <-h.Register:
 			if h.Client != nil {
 				// Allow only one connection from the Bridge
-				log.Println("WORKER: Bridge already connected. Rejecting new connection.")
+				slog.Warn("Bridge already connected, rejecting new connection")
 				client.Conn.Close()
 			} else {
 				h.Client = client
-				log.Println("WORKER: Bridge registered.")
+				bridgeConnected.Set(1)
+				slog.Info("Bridge registered")
 			}
 		case <-h.Unregister:
 			if h.Client != nil {
-				log.Println("WORKER: Bridge unregistered.")
+				slog.Info("Bridge unregistered")
 				h.Client = nil
+				bridgeConnected.Set(0)
 			}
 		}
 	}
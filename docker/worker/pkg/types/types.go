@@ -1,8 +1,20 @@
 package types
 
+import "encoding/json"
+
 type Message struct {
 	Event string      `json:"event"`
 	Data  interface{} `json:"data,omitempty"`
+	Meta  *Meta       `json:"meta,omitempty"`
+}
+
+// Meta carries correlation identifiers set by the bridge. Replies echo it back.
+type Meta struct {
+	WorkspaceID string `json:"workspaceId,omitempty"`
+	ClientID    string `json:"clientId,omitempty"`
+	AckID       string `json:"ackId,omitempty"`
+	// TraceParent is a W3C traceparent value linking spans across services.
+	TraceParent string `json:"traceparent,omitempty"`
 }
 
 type Acknowledge struct {
@@ -15,6 +27,97 @@ type FileRequest struct {
 	TargetPath  string `json:"targetPath"`
 	FileContent string `json:"fileContent,omitempty"`
 	AckID       string `json:"ackID,omitempty"`
+	// Encoding of FileContent: "utf-8" (the default) or "base64".
+	Encoding string `json:"encoding,omitempty"`
+	// Offset and Length make crud-read-file read a range of the file.
+	Offset int64 `json:"offset,omitempty"`
+	Length int64 `json:"length,omitempty"`
+	// Stream lets crud-read-file send a file over the inline size limit as
+	// file-chunk events rather than fail.
+	Stream bool `json:"stream,omitempty"`
+	// ExpectedVersion makes crud-update-file fail with a conflict unless the
+	// file is still at this version, as crud-read-file returned it.
+	ExpectedVersion string `json:"expectedVersion,omitempty"`
+	// Depth is how many levels crud-read-folder returns; 0 or 1 is just the
+	// folder's own entries.
+	Depth int `json:"depth,omitempty"`
+}
+
+// FileContent is a file read for the editor. Content is base64 when Encoding
+// says so, for binary files.
+type FileContent struct {
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+	// Version is a hash of the content, to send back as the expected version
+	// of an update.
+	Version string `json:"version"`
+}
+
+// FileRange is part of a file, read with an offset and length.
+type FileRange struct {
+	Content  string `json:"content"`  // Base64
+	Encoding string `json:"encoding"` // Always "base64"
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+	Size     int64  `json:"size"` // Of the whole file
+	EOF      bool   `json:"eof"`
+}
+
+// ChunkRequest is one chunk of a chunked write. Offset 0 starts the upload;
+// the chunk marked Final carries the SHA-256 checksum, in hex, of the whole
+// file, which is only written once it matches. Messages are handled
+// concurrently, so send each chunk only after the previous one is acked.
+type ChunkRequest struct {
+	TargetPath string `json:"targetPath"`
+	Offset     int64  `json:"offset"`
+	Content    string `json:"content"` // Base64
+	Final      bool   `json:"final,omitempty"`
+	Checksum   string `json:"checksum,omitempty"`
+	Abort      bool   `json:"abort,omitempty"` // Discard the upload instead
+	// ExpectedVersion, on the final chunk, makes the write fail with a
+	// conflict unless the file is still at this version.
+	ExpectedVersion string `json:"expectedVersion,omitempty"`
+}
+
+// PatchRequest edits part of a text file at a known version, rather than
+// sending all of it. As with the changes of one editor event, every range
+// refers to the file at ExpectedVersion and no two overlap.
+type PatchRequest struct {
+	TargetPath      string     `json:"targetPath"`
+	ExpectedVersion string     `json:"expectedVersion"`
+	Edits           []TextEdit `json:"edits"`
+}
+
+// TextEdit replaces a range of a text file, in the shape of the editor's
+// content change events.
+type TextEdit struct {
+	Range TextRange `json:"range"`
+	Text  string    `json:"text"`
+}
+
+// TextRange spans from a start to an end position. Lines are 1-based, as are
+// columns, which count UTF-16 code units.
+type TextRange struct {
+	StartLineNumber int `json:"startLineNumber"`
+	StartColumn     int `json:"startColumn"`
+	EndLineNumber   int `json:"endLineNumber"`
+	EndColumn       int `json:"endColumn"`
+}
+
+// TrashEntry is a deleted file or folder kept in the trash.
+type TrashEntry struct {
+	ID        string `json:"id"`
+	Path      string `json:"path"` // Where it was deleted from
+	DeletedMs int64  `json:"deletedMs"`
+	IsDir     bool   `json:"isDir"`
+	Size      int64  `json:"size"` // In bytes, of every file in a folder
+}
+
+type RestoreRequest struct {
+	ID    string `json:"id"` // Trash ID, as crud-delete-resource returned it
+	AckID string `json:"ackID,omitempty"`
 }
 
 type MoveRequest struct {
@@ -24,13 +127,46 @@ type MoveRequest struct {
 }
 
 type DirectoryEntry struct {
-	Type string `json:"type"`
-	Path string `json:"path"`
-	Name string `json:"name"`
+	Type string    `json:"type"`
+	Path string    `json:"path"`
+	Name string    `json:"name"`
+	Stat *FileStat `json:"stat,omitempty"`
+	// Children holds a folder's entries when it was read to a greater depth.
+	// It is absent for folders not read, and empty for empty folders.
+	Children []DirectoryEntry `json:"children,omitzero"`
+}
+
+// FileStat is the metadata of a file or folder. For a symlink it describes
+// the link itself, with Target giving where it points.
+type FileStat struct {
+	Size       int64  `json:"size"`
+	ModifiedMs int64  `json:"modifiedMs"` // Unix milliseconds
+	Mode       string `json:"mode"`       // Permission bits in octal, such as "0644"
+	Executable bool   `json:"executable"`
+	Symlink    bool   `json:"symlink,omitempty"`
+	Target     string `json:"target,omitempty"`
 }
 
 type TerminalRequest struct {
 	ID string `json:"id"`
+	// Initial window size for create-terminal; zero means the default 80x24.
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+	// Shell, Cwd and Env override the config.toml defaults for a new terminal.
+	Shell string            `json:"shell,omitempty"`
+	Cwd   string            `json:"cwd,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+}
+
+type TerminalSignal struct {
+	ID     string `json:"id"`
+	Signal string `json:"signal"` // e.g. "SIGINT"
+}
+
+type TerminalResize struct {
+	ID   string `json:"id"`
+	Cols uint16 `json:"cols"`
+	Rows uint16 `json:"rows"`
 }
 
 type TerminalInput struct {
@@ -38,8 +174,96 @@ type TerminalInput struct {
 	Input string `json:"input"`
 }
 
+// ExecRequest runs a command to completion outside any terminal.
+type ExecRequest struct {
+	// ID tags the exec-output events of this run; generated when empty.
+	ID        string            `json:"id,omitempty"`
+	Command   string            `json:"command"`
+	Args      []string          `json:"args,omitempty"`
+	Cwd       string            `json:"cwd,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	TimeoutMs int64             `json:"timeoutMs,omitempty"`
+}
+
+// ReplayRequest replays a terminal recording, either a file recorded by the
+// worker or events sent inline.
+type ReplayRequest struct {
+	ID   string `json:"id,omitempty"` // Terminal to create; generated when empty
+	Mode string `json:"mode"`         // "input" or "output"
+	// Recording is a file name as reported in terminal-created.
+	Recording string `json:"recording,omitempty"`
+	// Events is an inline recording of asciicast v2 events
+	// ([seconds, "o" | "i" | "r", data]) at size Cols x Rows.
+	Events json.RawMessage `json:"events,omitempty"`
+	Cols   uint16          `json:"cols,omitempty"`
+	Rows   uint16          `json:"rows,omitempty"`
+	// Speed multiplies the recorded pace, 1 by default; 0 replays without delays.
+	Speed *float64 `json:"speed,omitempty"`
+	// Until stops the replay this many seconds into the recording.
+	Until float64 `json:"until,omitempty"`
+	// Shell, Cwd and Env configure the terminal of an input replay.
+	Shell string            `json:"shell,omitempty"`
+	Cwd   string            `json:"cwd,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+}
+
 type HydrateFileRequest struct {
 	TargetPath    string `json:"targetPath"`
 	ContentBase64 string `json:"contentBase64"` // Content is sent as a base64 string
 	AckID         string `json:"ackID,omitempty"`
 }
+
+// SearchRequest searches file contents in the workspace.
+type SearchRequest struct {
+	// ID names the search; a new search with the same ID cancels the old one.
+	ID            string   `json:"id,omitempty"`
+	Query         string   `json:"query"`
+	Regex         bool     `json:"regex,omitempty"`
+	CaseSensitive bool     `json:"caseSensitive,omitempty"`
+	Include       []string `json:"include,omitempty"` // Globs such as "*.go" or "src/**"
+	Exclude       []string `json:"exclude,omitempty"`
+	MaxResults    int      `json:"maxResults,omitempty"`
+	TargetPath    string   `json:"targetPath,omitempty"` // Folder to search, the workspace by default
+}
+
+// SearchMatch is one occurrence of a search query. Line and Column are
+// 1-based, and Column and Length count UTF-16 code units as the editor does.
+type SearchMatch struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Length  int    `json:"length"`
+	Preview string `json:"preview"`
+}
+
+// ReplaceRequest replaces a search query across the workspace. Without Apply
+// it only previews; with it, it changes the previewed Files.
+type ReplaceRequest struct {
+	Query         string   `json:"query"`
+	Regex         bool     `json:"regex,omitempty"`
+	CaseSensitive bool     `json:"caseSensitive,omitempty"`
+	Include       []string `json:"include,omitempty"`
+	Exclude       []string `json:"exclude,omitempty"`
+	TargetPath    string   `json:"targetPath,omitempty"`
+	Replacement   string   `json:"replacement"`
+	Apply         bool     `json:"apply,omitempty"`
+	// Files confirms the preview: the files to change and the versions
+	// they were previewed at.
+	Files []ReplaceFile `json:"files,omitempty"`
+}
+
+// ReplaceFile is one file of a replace. Version is a hash of its content.
+type ReplaceFile struct {
+	Path         string `json:"path"`
+	Version      string `json:"version"`
+	Replacements int    `json:"replacements,omitempty"`
+	Diff         string `json:"diff,omitempty"` // Unified diff, in previews only
+}
+
+// FileMatch is a file found by a fuzzy file name query. Positions are the
+// UTF-16 offsets in Path of the matched characters, for highlighting.
+type FileMatch struct {
+	Path      string  `json:"path"`
+	Score     float64 `json:"score"`
+	Positions []int   `json:"positions,omitempty"`
+}
@@ -342,6 +342,8 @@ export interface TerminalOutPayload {
 export interface TerminalExitPayload {
   id: string;
   code: number;
+  /** Signal that killed the shell (e.g. 'SIGHUP'), empty for a normal exit */
+  signal?: string;
 }
 
 // ============================================================================